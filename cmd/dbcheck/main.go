@@ -1,12 +1,18 @@
 // package main provides the dbcheck tool which checks zone data stored in a
-// directory for common loading errors
+// directory for common loading errors. All problems found are reported, not
+// only the first one. Zone data that loads is checked with lint rules for
+// likely mistakes, see package lint. Only lint errors fail the check, unless
+// warnings are asked to fail it as well.
 package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/lint"
 	"github.com/egymgmbh/dns-tools/rrdb"
 )
 
@@ -14,26 +20,94 @@ func main() {
 	exitOK := true
 	configFile := flag.String("config-file", "config.yml",
 		"DNS Tools configuration file.")
+	noLint := flag.Bool("no-lint", false, "Do not run any lint rules.")
+	disable := flag.String("disable", "",
+		"Comma-separated list of lint rules to disable.")
+	listRules := flag.Bool("list-rules", false, "List the lint rules and exit.")
+	lintFail := flag.Bool("lint-fail", false,
+		"Fail on lint warnings, not only on lint errors.")
 	flag.Parse()
 
+	if *listRules {
+		for _, rule := range lint.Rules {
+			fmt.Printf("%-20v %-8v %v\n", rule.Name, rule.Severity,
+				rule.Description)
+		}
+		return
+	}
+	disabled := []string{}
+	for _, name := range strings.Split(*disable, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, err := lint.FindRule(name); err != nil {
+			log.Fatal(err)
+		}
+		disabled = append(disabled, name)
+	}
+
 	config, err := config.New(*configFile)
 	if err != nil {
 		log.Fatalf("get configuration: %v", err)
 	}
 
-	db, err := rrdb.NewFromDirectory(config.ZoneDataDirectory)
-	if err != nil {
-		log.Fatal(err)
+	dbs, problems := rrdb.CheckDirectories(config.ZoneDataDirectories())
+	for _, problem := range problems {
+		log.Printf("%v: %v", problem.Severity, problem)
+	}
+	if problems.HasErrors() {
+		exitOK = false
 	}
 
+	managed := make(map[string]bool)
+	for _, mz := range config.ManagedZones {
+		if mz.Visibility == "public" {
+			managed[mz.FQDN] = true
+		}
+	}
 	for _, mz := range config.ManagedZones {
-		_, err := db.Zone(mz.FQDN, mz.TTL)
+		db, err := dbs[mz.ZoneDataDirectory].View(mz.View)
 		if err != nil {
-			log.Printf("Managed zone %v: %v", mz.FQDN, err)
+			log.Printf("Managed zone %v: %v", mz.ID(), err)
 			exitOK = false
 			continue
 		}
+		records, err := db.Zone(mz.FQDN, mz.TTL)
+		if err != nil {
+			log.Printf("Managed zone %v: %v", mz.ID(), err)
+			exitOK = false
+			continue
+		}
+		// delegations to managed zones must refer to public managed zones
+		for _, record := range records {
+			if record.RType != "NS" || len(record.RDatas) != 0 {
+				continue
+			}
+			if !managed[record.FQDN] {
+				log.Printf("Managed zone %v: delegation %v: managed zone not found",
+					mz.ID(), record.FQDN)
+				exitOK = false
+			}
+		}
 	}
+
+	// lint rules need complete zone data
+	if !*noLint && exitOK {
+		findings, err := lint.Lint(config, dbs, disabled)
+		if err != nil {
+			log.Fatalf("lint: %v", err)
+		}
+		for _, finding := range findings {
+			log.Printf("lint %v: %v", finding.Severity, finding)
+			if finding.Severity == rrdb.SeverityError || *lintFail {
+				exitOK = false
+			}
+		}
+	} else if !*noLint {
+		log.Print("Lint rules skipped because of errors.")
+	}
+
 	if exitOK {
 		log.Print("Looks good!")
 	} else {
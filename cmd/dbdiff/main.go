@@ -0,0 +1,247 @@
+// Package main provides the dbdiff tool which previews the DNS impact of
+// zonedata changes without access to Cloud DNS. It compares the zones of all
+// managed zones between two git revisions or two directories and prints a
+// Markdown summary suitable for pull request comments.
+package main
+
+import (
+	"archive/tar"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	clouddns "google.golang.org/api/dns/v1"
+
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/gcp"
+	"github.com/egymgmbh/dns-tools/rrdb"
+)
+
+// tree holds the configuration and zone data of one side of the comparison
+type tree struct {
+	config *config.Config
+	dbs    map[string]rrdb.Views // by configured zonedata directory
+}
+
+// loadTree loads the configuration and zone data found below a root
+// directory. Relative paths are resolved against the root.
+func loadTree(root, configFile string) (*tree, error) {
+	cfg, err := config.New(resolve(root, configFile))
+	if err != nil {
+		return nil, fmt.Errorf("get configuration: %v", err)
+	}
+	t := &tree{config: cfg, dbs: make(map[string]rrdb.Views)}
+	for _, directory := range cfg.ZoneDataDirectories() {
+		views, err := rrdb.NewViewsFromDirectory(resolve(root, directory))
+		if err != nil {
+			return nil, fmt.Errorf("zonedata %v: %v", directory, err)
+		}
+		t.dbs[directory] = views
+	}
+	return t, nil
+}
+
+func resolve(root, fname string) string {
+	if filepath.IsAbs(fname) {
+		return fname
+	}
+	return filepath.Join(root, fname)
+}
+
+// zone retrieves the resource record sets of a managed zone. A managed zone
+// missing in the configuration has none.
+func (t *tree) zone(id string) (*config.ManagedZoneConfig, []*clouddns.ResourceRecordSet, error) {
+	for _, mz := range t.config.ManagedZones {
+		if mz.ID() != id {
+			continue
+		}
+		db, err := t.dbs[mz.ZoneDataDirectory].View(mz.View)
+		if err != nil {
+			return nil, nil, err
+		}
+		records, err := db.Zone(mz.FQDN, mz.TTL)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &mz, gcp.RRDBRecordsToCloudDNSRecords(records), nil
+	}
+	return nil, []*clouddns.ResourceRecordSet{}, nil
+}
+
+// compare diffs the managed zones of two trees, in the order of the new
+// configuration followed by removed managed zones
+func compare(from, to *tree) ([]zoneDiff, error) {
+	ids := []string{}
+	seen := make(map[string]bool)
+	for _, cfg := range []*config.Config{to.config, from.config} {
+		for _, mz := range cfg.ManagedZones {
+			if !seen[mz.ID()] {
+				seen[mz.ID()] = true
+				ids = append(ids, mz.ID())
+			}
+		}
+	}
+	diffs := []zoneDiff{}
+	for _, id := range ids {
+		oldMZ, have, err := from.zone(id)
+		if err != nil {
+			return nil, fmt.Errorf("old managed zone %v: %v", id, err)
+		}
+		newMZ, want, err := to.zone(id)
+		if err != nil {
+			return nil, fmt.Errorf("new managed zone %v: %v", id, err)
+		}
+		zd := zoneDiff{diff: gcp.DiffRRSets(have, want, nil)}
+		switch {
+		case oldMZ == nil:
+			zd.mz, zd.status = *newMZ, "added"
+		case newMZ == nil:
+			zd.mz, zd.status = *oldMZ, "removed"
+		default:
+			zd.mz = *newMZ
+		}
+		diffs = append(diffs, zd)
+	}
+	return diffs, nil
+}
+
+// exportRevision extracts the files of a git revision below the current
+// directory into a temporary directory, which the caller has to remove
+func exportRevision(revision string) (string, error) {
+	dir, err := ioutil.TempDir("", "dbdiff")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("git", "archive", "--format=tar", revision)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err == nil {
+		err = extract(tar.NewReader(out), dir)
+		// always reap the process, but report the first error
+		if waitErr := cmd.Wait(); err == nil {
+			err = waitErr
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("git revision %v: %v", revision, err)
+	}
+	return dir, nil
+}
+
+// extract writes the directories, regular files and symbolic links of a tar
+// archive to a directory. Entries and link targets outside of the directory
+// and any other type of entry are errors.
+func extract(archive *tar.Reader, dir string) error {
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fname := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !within(dir, fname) {
+			return fmt.Errorf("%v: outside of the archive", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			// git archive stores the commit ID here
+		case tar.TypeDir:
+			err = os.MkdirAll(fname, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = os.MkdirAll(filepath.Dir(fname), 0755)
+			if err == nil {
+				var f *os.File
+				f, err = os.Create(fname)
+				if err == nil {
+					_, err = io.Copy(f, archive)
+					if closeErr := f.Close(); err == nil {
+						err = closeErr
+					}
+				}
+			}
+		case tar.TypeSymlink:
+			target := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(target) ||
+				!within(dir, filepath.Join(filepath.Dir(fname), target)) {
+				return fmt.Errorf("%v: link target %v outside of the archive",
+					header.Name, header.Linkname)
+			}
+			err = os.MkdirAll(filepath.Dir(fname), 0755)
+			if err == nil {
+				err = os.Symlink(target, fname)
+			}
+		default:
+			return fmt.Errorf("%v: unsupported type of entry %q", header.Name,
+				header.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// within checks if a path is equal to or below a directory
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// root returns the directory holding a side of the comparison: the directory
+// itself, or the exported files of a git revision. The returned function
+// cleans up.
+func root(dirOrRevision string) (string, func(), error) {
+	info, err := os.Stat(dirOrRevision)
+	if err == nil && info.IsDir() {
+		return dirOrRevision, func() {}, nil
+	}
+	dir, err := exportRevision(dirOrRevision)
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }, nil
+}
+
+func main() {
+	configFile := flag.String("config-file", "config.yml",
+		"DNS Tools configuration file, relative to the compared directories.")
+	from := flag.String("from", "HEAD",
+		"Directory or git revision with the old zone data.")
+	to := flag.String("to", ".",
+		"Directory or git revision with the new zone data.")
+	flag.Parse()
+
+	trees := []*tree{}
+	for _, side := range []string{*from, *to} {
+		dir, cleanup, err := root(side)
+		if err != nil {
+			log.Fatal(err)
+		}
+		t, err := loadTree(dir, *configFile)
+		cleanup()
+		if err != nil {
+			log.Fatalf("%v: %v", side, err)
+		}
+		trees = append(trees, t)
+	}
+	diffs, err := compare(trees[0], trees[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = report(os.Stdout, *from, *to, diffs)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
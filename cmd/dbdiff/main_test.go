@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	from, err := loadTree("testdata/old", "config.yml")
+	assert.Equal(t, nil, err)
+	to, err := loadTree("testdata/new", "config.yml")
+	assert.Equal(t, nil, err)
+
+	diffs, err := compare(from, to)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(diffs))
+
+	// new configuration first, then removed managed zones
+	assert.Equal(t, "example.com.", diffs[0].mz.ID())
+	assert.Equal(t, "", diffs[0].status)
+	assert.Equal(t, "example.net.", diffs[1].mz.ID())
+	assert.Equal(t, "added", diffs[1].status)
+	assert.Equal(t, "example.org.", diffs[2].mz.ID())
+	assert.Equal(t, "removed", diffs[2].status)
+
+	assert.Equal(t, []string{
+		"apex `example.com. MX`: modify rdata",
+		"`example.com. MX`: removes `20 mx2.example.com.`",
+		"`bar.example.com. NS` deleted",
+		"`foo.example.com. CNAME`: TTL drops from 300 to 60",
+	}, diffs[0].warnings())
+	assert.Equal(t, []string{"managed zone added"}, diffs[1].warnings())
+	assert.Equal(t, []string{"managed zone removed"}, diffs[2].warnings())
+
+	var out bytes.Buffer
+	assert.Equal(t, nil, report(&out, "old", "new", diffs))
+	assert.Equal(t, "## DNS changes from `old` to `new`\n\n"+
+		"| Managed zone | Added | Modified | Deleted |\n"+
+		"|---|--:|--:|--:|\n"+
+		"| example.com. | 1 | 2 | 1 |\n"+
+		"| example.net. (added) | 1 | 0 | 0 |\n"+
+		"| example.org. (removed) | 0 | 0 | 1 |\n"+
+		"\n### :warning: Risky changes\n\n"+
+		"- **example.com.** apex `example.com. MX`: modify rdata\n"+
+		"- **example.com.** `example.com. MX`: removes `20 mx2.example.com.`\n"+
+		"- **example.com.** `bar.example.com. NS` deleted\n"+
+		"- **example.com.** `foo.example.com. CNAME`: TTL drops from 300 to 60\n"+
+		"- **example.net.** managed zone added\n"+
+		"- **example.org.** managed zone removed\n"+
+		"\n<details><summary>example.com.</summary>\n\n```diff\n"+
+		"@@ example.com. MX @@ modify rdata\n"+
+		" example.com. 300 IN MX 10 mx1.example.com.\n"+
+		"-example.com. 300 IN MX 20 mx2.example.com.\n"+
+		"@@ bar.example.com. NS @@ delete\n"+
+		"-bar.example.com. 300 IN NS ns1.example.org.\n"+
+		"-bar.example.com. 300 IN NS ns2.example.org.\n"+
+		"@@ foo.example.com. CNAME @@ modify TTL\n"+
+		"-foo.example.com. 300 IN CNAME foo.example.org.\n"+
+		"+foo.example.com. 60 IN CNAME foo.example.org.\n"+
+		"@@ www.example.com. A @@ add\n"+
+		"+www.example.com. 300 IN A 192.0.2.1\n"+
+		"```\n\n</details>\n"+
+		"\n<details><summary>example.net.</summary>\n\n```diff\n"+
+		"@@ www.example.net. A @@ add\n"+
+		"+www.example.net. 300 IN A 192.0.2.1\n"+
+		"```\n\n</details>\n"+
+		"\n<details><summary>example.org.</summary>\n\n```diff\n"+
+		"@@ www.example.org. A @@ delete\n"+
+		"-www.example.org. 300 IN A 192.0.2.1\n"+
+		"```\n\n</details>\n", out.String())
+}
+
+func TestReportNoChanges(t *testing.T) {
+	from, err := loadTree("testdata/old", "config.yml")
+	assert.Equal(t, nil, err)
+	diffs, err := compare(from, from)
+	assert.Equal(t, nil, err)
+
+	var out bytes.Buffer
+	assert.Equal(t, nil, report(&out, "old", "old", diffs))
+	assert.Equal(t, "## DNS changes from `old` to `old`\n\n"+
+		"No changes in 2 managed zones.\n", out.String())
+}
+
+// testArchive builds a tar archive of entries
+func testArchive(t *testing.T, headers ...*tar.Header) *tar.Reader {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, header := range headers {
+		header.Size = int64(len(header.Name))
+		if header.Typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		assert.Equal(t, nil, w.WriteHeader(header))
+		if header.Size > 0 {
+			_, err := w.Write([]byte(header.Name))
+			assert.Equal(t, nil, err)
+		}
+	}
+	assert.Equal(t, nil, w.Close())
+	return tar.NewReader(&buf)
+}
+
+func TestExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbdiff")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, nil, extract(testArchive(t,
+		&tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader},
+		&tar.Header{Name: "zonedata/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "zonedata/a.yml", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "config.yml", Typeflag: tar.TypeSymlink,
+			Linkname: "zonedata/a.yml"},
+	), dir))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.yml"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "zonedata/a.yml", string(data))
+
+	for _, header := range []*tar.Header{
+		{Name: "../a.yml", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "zonedata/../../a.yml", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b.yml", Typeflag: tar.TypeSymlink, Linkname: "../a.yml"},
+		{Name: "c.yml", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "d.yml", Typeflag: tar.TypeLink, Linkname: "config.yml"},
+		{Name: "fifo", Typeflag: tar.TypeFifo},
+	} {
+		assert.NotEqual(t, nil, extract(testArchive(t, header), dir), header.Name)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/gcp"
+	"github.com/egymgmbh/dns-tools/rrdb"
+)
+
+// zoneDiff holds the changes of a managed zone. The status tells whether the
+// managed zone itself was added to or removed from the configuration.
+type zoneDiff struct {
+	mz     config.ManagedZoneConfig
+	status string // added, removed or empty
+	diff   gcp.Diff
+}
+
+// warnings returns human readable descriptions of the changes of a managed
+// zone that are risky to deploy: changes of the zone apex, removal of mail
+// servers and nameservers, and lowered TTLs, which only take effect after the
+// old TTL expired in caches
+func (zd zoneDiff) warnings() []string {
+	out := []string{}
+	if zd.status != "" {
+		out = append(out, fmt.Sprintf("managed zone %v", zd.status))
+	}
+	for _, op := range zd.diff {
+		rrset := fmt.Sprintf("`%v %v`", op.Name, op.Type)
+		if strings.EqualFold(op.Name, zd.mz.FQDN) {
+			out = append(out, fmt.Sprintf("apex %v: %v", rrset, op.Kind))
+		}
+		if op.Type == "MX" || op.Type == "NS" {
+			switch {
+			case op.Kind == gcp.OpDelete:
+				out = append(out, fmt.Sprintf("%v deleted", rrset))
+			case op.Kind&gcp.OpModifyRData != 0:
+				removed := removedRDatas(op)
+				if len(removed) != 0 {
+					out = append(out, fmt.Sprintf("%v: removes %v", rrset,
+						strings.Join(removed, ", ")))
+				}
+			}
+		}
+		if op.Kind&gcp.OpModifyTTL != 0 && op.After.Ttl < op.Before.Ttl {
+			out = append(out, fmt.Sprintf("%v: TTL drops from %v to %v", rrset,
+				op.Before.Ttl, op.After.Ttl))
+		}
+	}
+	return out
+}
+
+// removedRDatas returns the data of a modified resource record set that is
+// not there anymore after the modification
+func removedRDatas(op gcp.Operation) []string {
+	kept := make(map[string]bool)
+	for _, rdata := range rrdb.CanonicalRDatas(op.Type, op.After.Rrdatas) {
+		kept[rdata] = true
+	}
+	removed := []string{}
+	for _, rdata := range rrdb.CanonicalRDatas(op.Type, op.Before.Rrdatas) {
+		if !kept[rdata] {
+			removed = append(removed, fmt.Sprintf("`%v`", rdata))
+		}
+	}
+	return removed
+}
+
+// report writes a Markdown summary of the changes of all managed zones: a
+// table of the number of changed resource record sets per zone, the risky
+// changes and the diff of every changed zone
+func report(w io.Writer, from, to string, diffs []zoneDiff) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "## DNS changes from `%v` to `%v`\n\n", from, to)
+
+	changed := []zoneDiff{}
+	for _, zd := range diffs {
+		if zd.status != "" || zd.diff.HasChanges() {
+			changed = append(changed, zd)
+		}
+	}
+	if len(changed) == 0 {
+		fmt.Fprintf(out, "No changes in %v managed zones.\n", len(diffs))
+		return out.Flush()
+	}
+
+	fmt.Fprintf(out, "| Managed zone | Added | Modified | Deleted |\n")
+	fmt.Fprintf(out, "|---|--:|--:|--:|\n")
+	for _, zd := range changed {
+		name := zd.mz.ID()
+		if zd.status != "" {
+			name += fmt.Sprintf(" (%v)", zd.status)
+		}
+		fmt.Fprintf(out, "| %v | %v | %v | %v |\n", name,
+			zd.diff.Count(gcp.OpAdd),
+			zd.diff.Count(gcp.OpModifyTTL|gcp.OpModifyRData),
+			zd.diff.Count(gcp.OpDelete))
+	}
+	if unchanged := len(diffs) - len(changed); unchanged != 0 {
+		fmt.Fprintf(out, "\n%v managed zones unchanged.\n", unchanged)
+	}
+
+	warnings := []string{}
+	for _, zd := range changed {
+		for _, warning := range zd.warnings() {
+			warnings = append(warnings, fmt.Sprintf("- **%v** %v", zd.mz.ID(),
+				warning))
+		}
+	}
+	if len(warnings) != 0 {
+		fmt.Fprintf(out, "\n### :warning: Risky changes\n\n%v\n",
+			strings.Join(warnings, "\n"))
+	}
+
+	for _, zd := range changed {
+		if len(zd.diff) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n<details><summary>%v</summary>\n\n```diff\n%v\n```\n\n</details>\n",
+			zd.mz.ID(), strings.Join(zd.diff.Format(), "\n"))
+	}
+	return out.Flush()
+}
@@ -0,0 +1,120 @@
+// Package main provides the dbresolve tool which prints the zone data of
+// managed zones after resolving templates, overrides and variables, along with
+// the origin of every record. Alternatively, it reports where variables are
+// used.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/rrdb"
+)
+
+// describe returns a human readable description of where records come from
+func describe(origin rrdb.Origin) string {
+	if len(origin.Templates) == 0 {
+		return fmt.Sprintf("%v (zone %v)", origin.Position, origin.Zone)
+	}
+	return fmt.Sprintf("%v (template %v, zone %v at %v)", origin.Position,
+		strings.Join(origin.Templates, " via "), origin.Zone,
+		origin.ZonePosition)
+}
+
+// printVariables prints the variables of every zonedata directory along with
+// the names using them
+func printVariables(directories []string) error {
+	for _, directory := range directories {
+		variables, err := rrdb.VariablesFromDirectory(directory)
+		if err != nil {
+			return fmt.Errorf("directory %v: %v", directory, err)
+		}
+		fmt.Printf("; zonedata %v\n", directory)
+		for _, variable := range variables {
+			fmt.Printf("$%v (%v): %v\n", variable.Name, variable.Position,
+				strings.Join(variable.Values, " "))
+			if len(variable.Uses) == 0 {
+				fmt.Printf("\tunused\n")
+			}
+			for _, use := range variable.Uses {
+				fmt.Printf("\t%v %v, %v\n", use.FQDN,
+					strings.Join(use.RTypes, " "), describe(use))
+			}
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func main() {
+	configFile := flag.String("config-file", "config.yml",
+		"DNS Tools configuration file.")
+	zone := flag.String("zone", "",
+		"Only print the managed zone with this FQDN.")
+	variables := flag.Bool("variables", false,
+		"Print where variables are used instead of zone data.")
+	flag.Parse()
+
+	config, err := config.New(*configFile)
+	if err != nil {
+		log.Fatalf("get configuration: %v", err)
+	}
+
+	if *variables {
+		err = printVariables(config.ZoneDataDirectories())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	dbs, err := rrdb.NewFromDirectories(config.ZoneDataDirectories())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, mz := range config.ManagedZones {
+		if *zone != "" && mz.FQDN != *zone {
+			continue
+		}
+		db, err := dbs[mz.ZoneDataDirectory].View(mz.View)
+		if err != nil {
+			log.Fatalf("managed zone %v: %v", mz.ID(), err)
+		}
+		records, err := db.Zone(mz.FQDN, mz.TTL)
+		if err != nil {
+			log.Fatalf("managed zone %v: %v", mz.ID(), err)
+		}
+		origins, err := rrdb.OriginsFromDirectory(mz.ZoneDataDirectory, mz.View)
+		if err != nil {
+			log.Fatalf("managed zone %v: %v", mz.ID(), err)
+		}
+		descriptions := make(map[string][]string)
+		for _, origin := range origins {
+			for _, rtype := range origin.RTypes {
+				key := origin.FQDN + " " + rtype
+				descriptions[key] = append(descriptions[key], describe(origin))
+			}
+		}
+
+		fmt.Printf("; managed zone %v, view %q, zonedata %v\n", mz.ID(), mz.View,
+			mz.ZoneDataDirectory)
+		for _, record := range records {
+			for _, description := range descriptions[record.FQDN+" "+record.RType] {
+				fmt.Printf("; from %v\n", description)
+			}
+			if record.RType == "NS" && len(record.RDatas) == 0 {
+				fmt.Printf("; %v\t%v\tIN\tNS\t(nameservers of managed zone %v)\n",
+					record.FQDN, record.TTL, record.FQDN)
+			}
+			for _, rdata := range record.RDatas {
+				fmt.Printf("%v\t%v\tIN\t%v\t%v\n", record.FQDN, record.TTL,
+					record.RType, rdata)
+			}
+		}
+		fmt.Println()
+	}
+}
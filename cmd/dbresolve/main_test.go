@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/egymgmbh/dns-tools/rrdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "zones.yml:8:7 (zone example.com.)", describe(rrdb.Origin{
+		FQDN:         "www.example.com.",
+		Position:     "zones.yml:8:7",
+		Zone:         "example.com.",
+		ZonePosition: "zones.yml:3:5",
+	}))
+	assert.Equal(t,
+		"t.yml:9:7 (template spf (t.yml:3:5) via mail (t.yml:12:5), zone example.com. at zones.yml:3:5)",
+		describe(rrdb.Origin{
+			FQDN:         "example.com.",
+			Position:     "t.yml:9:7",
+			Zone:         "example.com.",
+			ZonePosition: "zones.yml:3:5",
+			Templates:    []string{"spf (t.yml:3:5)", "mail (t.yml:12:5)"},
+		}))
+}
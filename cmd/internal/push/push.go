@@ -0,0 +1,77 @@
+// Package push holds the flow the tools share to push a diff to Cloud DNS:
+// The diff is logged, the deployment delay gives a last chance to abort and,
+// unless this is a dry run, the diff is applied in batches.
+package push
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fatih/color"
+	clouddns "google.golang.org/api/dns/v1"
+
+	"github.com/egymgmbh/dns-tools/gcp"
+)
+
+// Options holds the safeguards of pushing a diff to Cloud DNS
+type Options struct {
+	Delay   time.Duration // wait before taking action, giving a chance to abort
+	DryRun  bool          // do not take action, just pretend
+	Timeout time.Duration // wait at most this long for the changes to be done
+}
+
+// Push logs a diff in a human-friendly way and applies it to a managed zone
+// on Cloud DNS after the delay. Big diffs are split into batches Cloud DNS
+// accepts, see gcp.ApplyBatches. Push returns the operations that were
+// applied: none if there was nothing to change or this is a dry run, and the
+// ones of the batches applied before a failure.
+func Push(service *clouddns.Service, projectID, mzName string, diff gcp.Diff, opts Options) (gcp.Diff, error) {
+	log.Printf("%v RRsets to be added, %v to be modified, %v to be deleted, "+
+		"%v unmanaged", diff.Count(gcp.OpAdd),
+		diff.Count(gcp.OpModifyTTL|gcp.OpModifyRData),
+		diff.Count(gcp.OpDelete), diff.Count(gcp.OpUnmanaged))
+	for _, line := range diff.Format() {
+		switch line[0] {
+		case '-':
+			color.Set(color.FgRed)
+		case '+':
+			color.Set(color.FgGreen)
+		}
+		log.Print(line)
+		color.Unset()
+	}
+	if !diff.HasChanges() {
+		log.Println("nothing to change")
+		return gcp.Diff{}, nil
+	}
+
+	// enforcing deployment delay
+	if opts.Delay > 0 {
+		log.Printf("delaying change for %v seconds...", opts.Delay)
+		log.Printf("last chance to abort!")
+		time.Sleep(opts.Delay)
+	}
+
+	// back out if this is a dry-run
+	if opts.DryRun {
+		color.Set(color.FgHiYellow)
+		log.Printf("skipping action! (dry run)")
+		color.Unset()
+		return gcp.Diff{}, nil
+	}
+
+	batches := diff.Batches(gcp.MaxChangeSize)
+	log.Printf("requesting change (%v batches)...", len(batches))
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	err := gcp.ApplyBatches(ctx, service, projectID, mzName, batches)
+	if batchErr, ok := err.(*gcp.BatchError); ok {
+		return diff.Applied(batches, batchErr.Applied), err
+	}
+	if err != nil {
+		return gcp.Diff{}, err
+	}
+	log.Printf("request status: done")
+	return diff, nil
+}
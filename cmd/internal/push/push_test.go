@@ -0,0 +1,38 @@
+package push
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clouddns "google.golang.org/api/dns/v1"
+
+	"github.com/egymgmbh/dns-tools/gcp"
+)
+
+func TestPush(t *testing.T) {
+	diff := gcp.DiffRRSets(nil, []*clouddns.ResourceRecordSet{
+		{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "www.example.com.",
+			Type:    "A",
+			Ttl:     300,
+			Rrdatas: []string{"192.0.2.1"},
+		},
+	}, nil)
+	opts := Options{Timeout: time.Minute}
+	// nothing to change, Cloud DNS is not contacted
+	{
+		applied, err := Push(nil, "project", "zone", gcp.Diff{}, opts)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, gcp.Diff{}, applied)
+	}
+	// dry run
+	{
+		dryRun := opts
+		dryRun.DryRun = true
+		applied, err := Push(nil, "project", "zone", diff, dryRun)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, gcp.Diff{}, applied)
+	}
+}
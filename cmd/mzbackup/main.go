@@ -0,0 +1,63 @@
+// Package main provides the mzbackup tool which saves snapshots of the
+// settings and records of all managed zones as they are live on Cloud DNS,
+// independent of the zone data. Every run adds a JSON file and a zone file per
+// managed zone, named after the time the snapshot was taken. See mzrestore for
+// restoring the records of a snapshot.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	clouddns "google.golang.org/api/dns/v1"
+
+	"github.com/egymgmbh/dns-tools/gcp"
+)
+
+func main() {
+	directory := flag.String("directory", "backup",
+		"Directory to save the snapshots in, one subdirectory per managed zone.")
+	gcpSAFile := flag.String("gcp-sa-file", "secret/gcp-sa.json",
+		"Google Cloud Platform Service Account file in JSON format.")
+	flag.Parse()
+
+	service, projectID, err := gcp.GetDNSService(*gcpSAFile, true)
+	if err != nil {
+		log.Fatalf("get DNS API service: %v", err)
+	}
+
+	var mzs []*clouddns.ManagedZone
+	err = service.ManagedZones.List(projectID).
+		Pages(context.Background(), func(page *clouddns.ManagedZonesListResponse) error {
+			mzs = append(mzs, page.ManagedZones...)
+			return nil
+		})
+	if err != nil {
+		log.Fatalf("list managed zones: %v", err)
+	}
+
+	exitOK := true
+	for _, mz := range mzs {
+		log.SetPrefix(gcp.ManagedZoneID(mz) + " ")
+		snapshot, err := gcp.TakeSnapshot(context.Background(), service,
+			projectID, mz)
+		if err != nil {
+			log.Printf("Cloud DNS: %v", err)
+			exitOK = false
+			continue
+		}
+		fname, err := snapshot.Save(*directory)
+		if err != nil {
+			log.Printf("save snapshot: %v", err)
+			exitOK = false
+			continue
+		}
+		log.Printf("%v RRsets saved to %v", len(snapshot.RRSets), fname)
+	}
+	log.SetPrefix("summary ")
+	log.Printf("%v managed zones", len(mzs))
+	if !exitOK {
+		log.Fatal("some errors occurred")
+	}
+}
@@ -1,5 +1,7 @@
-// Package main provides the mzcreate tool that creates managed zones on
-// Cloud DNS that are in the configuration file but missing on Cloud DNS.
+// Package main provides the mzcreate tool that reconciles the managed zones on
+// Cloud DNS with the configuration file: It creates missing managed zones,
+// updates the settings of existing managed zones, and reports (and optionally
+// deletes) managed zones that are not configured.
 package main
 
 import (
@@ -33,6 +35,8 @@ func main() {
 		"DNS Tools configuration file.")
 	dryRun := flag.Bool("dry-run", true,
 		"Do not take action on Cloud DNS. Just pretend.")
+	deleteOrphans := flag.Bool("delete-orphans", false,
+		"Delete empty managed zones on Cloud DNS that are not configured.")
 	noColor := flag.Bool("no-color", false, "Do not colorize output.")
 	gcpSAFile := flag.String("gcp-sa-file", "secret/gcp-sa.json",
 		"Google Cloud Platform Service Account file in JSON format.")
@@ -55,34 +59,119 @@ func main() {
 	if err != nil {
 		log.Fatalf("list managed zones: %v", err)
 	}
-	gcpManagedZones := make(map[string]bool)
+	gcpManagedZones := make(map[string]*clouddns.ManagedZone)
 	for _, mz := range mzlist.ManagedZones {
-		gcpManagedZones[mz.DnsName] = true
+		gcpManagedZones[gcp.ManagedZoneID(mz)] = mz
 	}
 
 	// compare configured managed zones with Cloud DNS managed zones
 	totalCreated := 0
+	totalUpdated := 0
+	configured := make(map[string]bool)
 	for _, mz := range config.ManagedZones {
-		log.SetPrefix(mz.FQDN + " ")
-		if _, ok := gcpManagedZones[mz.FQDN]; ok {
+		log.SetPrefix(mz.ID() + " ")
+		configured[mz.ID()] = true
+		gcpMZ, ok := gcpManagedZones[mz.ID()]
+		if !ok {
+			color.Set(color.FgHiYellow)
+			log.Printf("not on Cloud DNS")
+			color.Unset()
+			if *dryRun {
+				color.Set(color.FgHiYellow)
+				log.Printf("skipping action! (dry run)")
+				color.Unset()
+				continue
+			}
+			newMZ, err := service.ManagedZones.Create(projectID,
+				wantMZ(projectID, mz)).Do()
+			if err != nil {
+				color.Set(color.FgHiYellow)
+				log.Printf("api call: %v", err)
+				color.Unset()
+				exitOK = false
+				continue
+			}
+			log.Printf("created")
+			log.Printf("name:        %v", newMZ.Name)
+			log.Printf("nameservers: %q", newMZ.NameServers)
+			totalCreated++
+			continue
+		}
+
+		// reconcile the settings of an existing managed zone
+		diffs, patch := gcp.ManagedZoneDiff(projectID, mz, gcpMZ)
+		if len(diffs) == 0 {
 			log.Printf("OK")
 			continue
 		}
 		color.Set(color.FgHiYellow)
-		log.Printf("not on Cloud DNS")
+		for _, diff := range diffs {
+			log.Print(diff)
+		}
 		color.Unset()
+		if patch == nil {
+			color.Set(color.FgHiYellow)
+			log.Printf("manual action required")
+			color.Unset()
+			exitOK = false
+			continue
+		}
 		if *dryRun {
 			color.Set(color.FgHiYellow)
 			log.Printf("skipping action! (dry run)")
 			color.Unset()
 			continue
 		}
-		wantMZptr := &clouddns.ManagedZone{
-			DnsName:     mz.FQDN,
-			Name:        dnsNameToMZName(mz.FQDN),
-			Description: fmt.Sprintf("created by mzcreate %v", time.Now()),
+		_, err := service.ManagedZones.Patch(projectID, gcpMZ.Name, patch).Do()
+		if err != nil {
+			color.Set(color.FgHiYellow)
+			log.Printf("api call: %v", err)
+			color.Unset()
+			exitOK = false
+			continue
+		}
+		log.Printf("updated")
+		totalUpdated++
+	}
+
+	// find managed zones on Cloud DNS that are not in our configuration
+	totalOrphaned := 0
+	totalDeleted := 0
+	for _, gcpMZ := range mzlist.ManagedZones {
+		if configured[gcp.ManagedZoneID(gcpMZ)] {
+			continue
+		}
+		log.SetPrefix(gcp.ManagedZoneID(gcpMZ) + " ")
+		totalOrphaned++
+		color.Set(color.FgHiYellow)
+		log.Printf("not in configuration (name: %v)", gcpMZ.Name)
+		color.Unset()
+		if !*deleteOrphans {
+			continue
+		}
+		// safeguard: never delete a zone that still holds records
+		rrlist, err := service.ResourceRecordSets.List(projectID, gcpMZ.Name).Do()
+		if err != nil {
+			color.Set(color.FgHiYellow)
+			log.Printf("api call: %v", err)
+			color.Unset()
+			exitOK = false
+			continue
+		}
+		if !gcp.IsEmptyZone(rrlist.Rrsets, gcpMZ.DnsName) {
+			color.Set(color.FgHiYellow)
+			log.Printf("not empty, refusing to delete")
+			color.Unset()
+			exitOK = false
+			continue
+		}
+		if *dryRun {
+			color.Set(color.FgHiYellow)
+			log.Printf("skipping action! (dry run)")
+			color.Unset()
+			continue
 		}
-		newMZ, err := service.ManagedZones.Create(projectID, wantMZptr).Do()
+		err = service.ManagedZones.Delete(projectID, gcpMZ.Name).Do()
 		if err != nil {
 			color.Set(color.FgHiYellow)
 			log.Printf("api call: %v", err)
@@ -90,14 +179,48 @@ func main() {
 			exitOK = false
 			continue
 		}
-		log.Printf("created")
-		log.Printf("name:        %v", newMZ.Name)
-		log.Printf("nameservers: %q", newMZ.NameServers)
-		totalCreated++
+		log.Printf("deleted")
+		totalDeleted++
 	}
-	log.SetPrefix("summary")
-	log.Printf("%v managed zone create", totalCreated)
+
+	log.SetPrefix("summary ")
+	log.Printf("%v managed zones created, %v updated, %v orphaned, %v deleted",
+		totalCreated, totalUpdated, totalOrphaned, totalDeleted)
 	if !exitOK {
 		log.Fatal("some errors occurred")
 	}
 }
+
+// mzName returns the managed zone name for a managed zone configuration.
+// Private zones get a suffix, so they can coexist with public zones of the
+// same FQDN.
+func mzName(mz config.ManagedZoneConfig) string {
+	name := dnsNameToMZName(mz.FQDN)
+	if mz.Visibility == "private" {
+		name += "--private"
+	}
+	return name
+}
+
+// wantMZ builds a new Cloud DNS managed zone from its configuration
+func wantMZ(projectID string, mz config.ManagedZoneConfig) *clouddns.ManagedZone {
+	description := mz.Description
+	if description == "" {
+		description = fmt.Sprintf("created by mzcreate %v", time.Now())
+	}
+	want := &clouddns.ManagedZone{
+		DnsName:      mz.FQDN,
+		Name:         mzName(mz),
+		Description:  description,
+		Labels:       mz.Labels,
+		DnssecConfig: gcp.DNSSECConfig(mz.DNSSEC),
+		Visibility:   mz.Visibility,
+	}
+	if mz.Visibility == "private" {
+		want.PrivateVisibilityConfig = gcp.PrivateVisibilityConfig(projectID,
+			mz.Networks)
+		want.ForwardingConfig = gcp.ForwardingConfig(mz.Forwarding)
+		want.PeeringConfig = gcp.PeeringConfig(projectID, mz.Peering)
+	}
+	return want
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/egymgmbh/dns-tools/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,3 +15,14 @@ func TestDNSNameToMZName(t *testing.T) {
 	assert.Equal(t, "com--foo-example", dnsNameToMZName("foo-example.com"))
 	assert.Equal(t, "uk--co--xn--example", dnsNameToMZName("xn--example.co.uk"))
 }
+
+func TestMZName(t *testing.T) {
+	assert.Equal(t, "com--example", mzName(config.ManagedZoneConfig{
+		FQDN:       "example.com.",
+		Visibility: "public",
+	}))
+	assert.Equal(t, "com--example--private", mzName(config.ManagedZoneConfig{
+		FQDN:       "example.com.",
+		Visibility: "private",
+	}))
+}
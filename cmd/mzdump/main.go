@@ -9,6 +9,7 @@ import (
 	"os"
 
 	"github.com/egymgmbh/dns-tools/gcp"
+	clouddns "google.golang.org/api/dns/v1"
 )
 
 const defaultTemplate = `{{ range .ManagedZones }}
@@ -18,8 +19,26 @@ Managed Zone: {{ .DnsName }}
 {{- range .NameServers }}
   * {{ . -}}
 {{ end }}
+{{- if .DSRecords }}
+* DS Records:
+{{- range .DSRecords }}
+  * {{ . -}}
+{{ end }}
+{{- end }}
 {{ end }}`
 
+// managedZone extends a Cloud DNS managed zone with the DS records that have
+// to be published in the parent zone
+type managedZone struct {
+	*clouddns.ManagedZone
+	DSRecords []string
+}
+
+// templateData is handed to the output template
+type templateData struct {
+	ManagedZones []managedZone
+}
+
 func main() {
 	gcpSAFile := flag.String("gcp-sa-file", "secret/gcp-sa.json",
 		"Google Cloud Platform Service Account file in JSON format.")
@@ -48,7 +67,21 @@ func main() {
 	if err != nil {
 		log.Fatalf("list managed zones: %v", err)
 	}
-	err = tmpl.Execute(os.Stdout, managedZones)
+	data := templateData{}
+	for _, mz := range managedZones.ManagedZones {
+		var dsRecords []string
+		if mz.DnssecConfig != nil && mz.DnssecConfig.State == "on" {
+			dsRecords, err = gcp.GetDSRecords(service, projectID, mz.Name)
+			if err != nil {
+				log.Fatalf("managed zone %v: %v", mz.DnsName, err)
+			}
+		}
+		data.ManagedZones = append(data.ManagedZones, managedZone{
+			ManagedZone: mz,
+			DSRecords:   dsRecords,
+		})
+	}
+	err = tmpl.Execute(os.Stdout, data)
 	if err != nil {
 		log.Fatalf("execute template: %v", err)
 	}
@@ -1,7 +1,8 @@
 // Package main provides the mzmon tool which fetches managed zones from Cloud
 // DNS,looks up the nameservers for that zones, and compares the result with
-// the expected nameservers. It does that continously and writes the results
-// as time series metrics into an InfluxDB.
+// the expected nameservers. For DNSSEC-signed zones it also verifies that the
+// DS records at the parent match the zone's key signing keys. It does that
+// continously and writes the results as time series metrics into an InfluxDB.
 package main
 
 import (
@@ -60,9 +61,25 @@ func main() {
 	if err != nil {
 		log.Fatalf("register metric: %v", err)
 	}
+	gaugeDSOK := metrics.NewGauge()
+	err = metrics.Register("nsmon_"+projectID+"_total.DSOK", gaugeDSOK)
+	if err != nil {
+		log.Fatalf("register metric: %v", err)
+	}
+	gaugeDSMismatch := metrics.NewGauge()
+	err = metrics.Register("nsmon_"+projectID+"_total.DSMismatch", gaugeDSMismatch)
+	if err != nil {
+		log.Fatalf("register metric: %v", err)
+	}
+	gaugeDSError := metrics.NewGauge()
+	err = metrics.Register("nsmon_"+projectID+"_total.DSError", gaugeDSError)
+	if err != nil {
+		log.Fatalf("register metric: %v", err)
+	}
 
 	// main loop, where we check all managed zones and their delegations
 	mzMetrics := make(map[string]metrics.Gauge)
+	dsMetrics := make(map[string]metrics.Gauge)
 	for {
 		// fetch current managed zones
 		managedZones, err := service.ManagedZones.List(projectID).Do()
@@ -71,8 +88,13 @@ func main() {
 		}
 
 		var statsError, statsOK, statsMismatch int64
+		var statsDSError, statsDSOK, statsDSMismatch int64
 
 		for _, managedZone := range managedZones.ManagedZones {
+			// private zones are not delegated, there is nothing to check
+			if managedZone.Visibility == "private" {
+				continue
+			}
 			// sometimes, we discover new zones and need to create gauges on-the-fly
 			if _, ok := mzMetrics[managedZone.Name]; !ok {
 				mzMetrics[managedZone.Name] = metrics.NewGauge()
@@ -87,9 +109,7 @@ func main() {
 			if err != nil {
 				mzMetrics[managedZone.Name].Update(-1)
 				statsError++
-				continue
-			}
-			if len(curNameServers) > 0 &&
+			} else if len(curNameServers) > 0 &&
 				lib.RDatasEqual(curNameServers, managedZone.NameServers) {
 				mzMetrics[managedZone.Name].Update(1)
 				statsOK++
@@ -97,13 +117,73 @@ func main() {
 				mzMetrics[managedZone.Name].Update(0)
 				statsMismatch++
 			}
+
+			// DNSSEC: the parent's DS records must match our key signing keys
+			if managedZone.DnssecConfig == nil ||
+				managedZone.DnssecConfig.State != "on" {
+				continue
+			}
+			if _, ok := dsMetrics[managedZone.Name]; !ok {
+				dsMetrics[managedZone.Name] = metrics.NewGauge()
+				err = metrics.Register("nsmon_"+projectID+"_dnssec."+managedZone.Name,
+					dsMetrics[managedZone.Name])
+				if err != nil {
+					log.Fatalf("register metric: %v", err)
+				}
+			}
+			wantDSRecords, err := gcp.GetDSRecords(service, projectID,
+				managedZone.Name)
+			if err != nil {
+				log.Printf("%v: %v", managedZone.DnsName, err)
+				dsMetrics[managedZone.Name].Update(-1)
+				statsDSError++
+				continue
+			}
+			curDSRecords, err := lib.Lookup(managedZone.DnsName, "DS")
+			if err != nil {
+				log.Printf("%v: lookup DS: %v", managedZone.DnsName, err)
+				dsMetrics[managedZone.Name].Update(-1)
+				statsDSError++
+				continue
+			}
+			if dsRecordsMatch(curDSRecords, wantDSRecords) {
+				dsMetrics[managedZone.Name].Update(1)
+				statsDSOK++
+			} else {
+				dsMetrics[managedZone.Name].Update(0)
+				statsDSMismatch++
+			}
 		}
 
 		gaugeError.Update(statsError)
 		gaugeOK.Update(statsOK)
 		gaugeMismatch.Update(statsMismatch)
+		gaugeDSError.Update(statsDSError)
+		gaugeDSOK.Update(statsDSOK)
+		gaugeDSMismatch.Update(statsDSMismatch)
 		log.Printf("%v OK, %v Mismatch, %v Error", statsOK, statsMismatch, statsError)
+		log.Printf("DNSSEC: %v OK, %v Mismatch, %v Error",
+			statsDSOK, statsDSMismatch, statsDSError)
 
 		time.Sleep(pause)
 	}
 }
+
+// dsRecordsMatch returns true if the parent publishes at least one DS record
+// and all of them belong to one of our key signing keys. The parent may
+// publish only a subset of the digest types we know about.
+func dsRecordsMatch(parent, want []string) bool {
+	if len(parent) == 0 {
+		return false
+	}
+	known := make(map[string]bool)
+	for _, rdata := range want {
+		known[rdata] = true
+	}
+	for _, rdata := range parent {
+		if !known[rdata] {
+			return false
+		}
+	}
+	return true
+}
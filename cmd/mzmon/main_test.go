@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDSRecordsMatch(t *testing.T) {
+	want := []string{"12345 8 1 BEEF", "12345 8 2 C0FFEE"}
+	assert.Equal(t, true, dsRecordsMatch([]string{"12345 8 2 C0FFEE"}, want))
+	assert.Equal(t, true, dsRecordsMatch(want, want))
+	assert.Equal(t, false, dsRecordsMatch([]string{}, want))
+	assert.Equal(t, false, dsRecordsMatch([]string{"54321 8 2 C0FFEE"}, want))
+	assert.Equal(t, false,
+		dsRecordsMatch([]string{"12345 8 2 C0FFEE", "54321 8 2 C0FFEE"}, want))
+}
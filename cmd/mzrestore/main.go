@@ -0,0 +1,134 @@
+// Package main provides the mzrestore tool which restores the records of a
+// managed zone on Cloud DNS from a snapshot taken by mzbackup. It pushes the
+// difference between the live records and the snapshot the same way rrpush
+// does, including the dry run and the delay. Like rrpush, it only touches the
+// record types dns-tools manages and leaves the zone's own SOA and NS records
+// as well as the records of the zone's ignore rules alone. Managed zone
+// settings are not restored, see mzcreate.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/fatih/color"
+	clouddns "google.golang.org/api/dns/v1"
+
+	"github.com/egymgmbh/dns-tools/cmd/internal/push"
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/gcp"
+)
+
+// restoreDiff returns the operations restoring the records of a snapshot.
+// Records matching an ignore rule are left alone on both sides, as rrpush
+// does.
+func restoreDiff(live, snapshot []*clouddns.ResourceRecordSet, fqdn string, ignore []config.IgnoreRule) gcp.Diff {
+	return gcp.DiffRRSets(
+		gcp.FilterRRSets(gcp.WithoutIgnored(live, ignore), fqdn),
+		gcp.FilterRRSets(gcp.WithoutIgnored(snapshot, ignore), fqdn),
+		gcp.IgnoredRRSets(live, ignore))
+}
+
+func main() {
+	configFile := flag.String("config-file", "config.yml",
+		"DNS Tools configuration file.")
+	snapshotFile := flag.String("snapshot", "",
+		"Snapshot JSON file written by mzbackup.")
+	zone := flag.String("zone", "",
+		"Name of the Cloud DNS managed zone to restore. Default: the managed "+
+			"zone of the snapshot.")
+	delay := flag.String("delay", "10s",
+		"Safeguard: Wait [delay] before taking action on Cloud DNS.")
+	dryRun := flag.Bool("dry-run", true,
+		"Do not take action on Cloud DNS. Just pretend.")
+	gcpSAFile := flag.String("gcp-sa-file", "secret/gcp-sa.json",
+		"Google Cloud Platform Service Account file in JSON format.")
+	noColor := flag.Bool("no-color", false, "Do not colorize output.")
+	timeout := flag.String("timeout", "5m",
+		"Wait at most [timeout] for the changes to be done.")
+	flag.Parse()
+
+	// validate flags
+	if *snapshotFile == "" {
+		log.Fatal("missing snapshot file")
+	}
+	delayDuration, err := time.ParseDuration(*delay)
+	if err != nil {
+		log.Fatalf("parse delay: %v", err)
+	}
+	timeoutDuration, err := time.ParseDuration(*timeout)
+	if err != nil {
+		log.Fatalf("parse timeout: %v", err)
+	}
+	cfg, err := config.New(*configFile)
+	if err != nil {
+		log.Fatalf("load configuration: %v", err)
+	}
+	snapshot, err := gcp.LoadSnapshot(*snapshotFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mzName := *zone
+	if mzName == "" {
+		mzName = snapshot.ManagedZone.Name
+	}
+	service, projectID, err := gcp.GetDNSService(*gcpSAFile, *dryRun)
+	if err != nil {
+		log.Fatalf("get GCP service: %v", err)
+	}
+	color.NoColor = *noColor
+
+	// the records of a snapshot only fit a managed zone of the same domain
+	mz, err := service.ManagedZones.Get(projectID, mzName).Do()
+	if err != nil {
+		log.Fatalf("get managed zone %v: %v", mzName, err)
+	}
+	log.SetPrefix(gcp.ManagedZoneID(mz) + " ")
+	if mz.DnsName != snapshot.ManagedZone.DnsName {
+		log.Fatalf("snapshot of %v does not fit managed zone %v",
+			snapshot.ManagedZone.DnsName, mz.DnsName)
+	}
+	// the zone's ignore rules protect the records of other tools
+	var mzConfig *config.ManagedZoneConfig
+	for idx := range cfg.ManagedZones {
+		if cfg.ManagedZones[idx].ID() == gcp.ManagedZoneID(mz) {
+			mzConfig = &cfg.ManagedZones[idx]
+			break
+		}
+	}
+	if mzConfig == nil {
+		log.Fatal("local configuration: zone not found")
+	}
+	log.Printf("restoring snapshot of %v taken %v", snapshot.ManagedZone.Name,
+		snapshot.Taken.Format(time.RFC3339))
+
+	live, err := gcp.TakeSnapshot(context.Background(), service, projectID, mz)
+	if err != nil {
+		log.Fatalf("Cloud DNS: %v", err)
+	}
+	for _, rrset := range gcp.IgnoredRRSets(snapshot.RRSets, mzConfig.Ignore) {
+		color.Set(color.FgHiYellow)
+		log.Printf("snapshot: %v %v: ignored by configuration", rrset.Name,
+			rrset.Type)
+		color.Unset()
+	}
+	diff := restoreDiff(live.RRSets, snapshot.RRSets, mz.DnsName,
+		mzConfig.Ignore)
+	applied, err := push.Push(service, projectID, mz.Name, diff,
+		push.Options{
+			Delay:   delayDuration,
+			DryRun:  *dryRun,
+			Timeout: timeoutDuration,
+		})
+	if applied.HasChanges() {
+		log.Printf("%v RRsets added, %v modified, %v deleted",
+			applied.Count(gcp.OpAdd),
+			applied.Count(gcp.OpModifyTTL|gcp.OpModifyRData),
+			applied.Count(gcp.OpDelete))
+	}
+	if err != nil {
+		log.Fatalf("request failed: %v", err)
+	}
+}
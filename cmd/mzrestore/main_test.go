@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clouddns "google.golang.org/api/dns/v1"
+
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/gcp"
+)
+
+func TestRestoreDiff(t *testing.T) {
+	acme := &clouddns.ResourceRecordSet{
+		Kind:    "dns#resourceRecordSet",
+		Name:    "_acme-challenge.example.com.",
+		Type:    "TXT",
+		Ttl:     300,
+		Rrdatas: []string{`"token"`},
+	}
+	live := []*clouddns.ResourceRecordSet{
+		{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "example.com.",
+			Type:    "NS",
+			Ttl:     300,
+			Rrdatas: []string{"ns1.example.net."},
+		},
+		acme,
+		{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "www.example.com.",
+			Type:    "A",
+			Ttl:     300,
+			Rrdatas: []string{"192.0.2.2"},
+		},
+	}
+	snapshot := []*clouddns.ResourceRecordSet{
+		{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "example.com.",
+			Type:    "NS",
+			Ttl:     300,
+			Rrdatas: []string{"ns2.example.net."},
+		},
+		{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "_acme-challenge.example.com.",
+			Type:    "TXT",
+			Ttl:     300,
+			Rrdatas: []string{`"old token"`},
+		},
+		{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "www.example.com.",
+			Type:    "A",
+			Ttl:     300,
+			Rrdatas: []string{"192.0.2.1"},
+		},
+	}
+	ignore := []config.IgnoreRule{{Prefix: "_acme-challenge", Types: []string{"TXT"}}}
+
+	diff := restoreDiff(live, snapshot, "example.com.", ignore)
+	assert.Equal(t, 2, len(diff))
+	// the ignored live TXT record survives
+	assert.Equal(t, gcp.OpUnmanaged, diff[0].Kind)
+	assert.Equal(t, acme, diff[0].Before)
+	assert.Equal(t, gcp.OpModifyRData, diff[1].Kind)
+	assert.Equal(t, "www.example.com.", diff[1].Name)
+	assert.Equal(t, 0, diff.Count(gcp.OpDelete|gcp.OpAdd))
+
+	// without ignore rules, the snapshot's TXT record is restored
+	diff = restoreDiff(live, snapshot, "example.com.", nil)
+	assert.Equal(t, 2, diff.Count(gcp.OpModifyRData))
+}
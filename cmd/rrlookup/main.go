@@ -21,7 +21,7 @@ func main() {
 		log.Fatalf("get configuration: %v", err)
 	}
 
-	db, err := rrdb.NewFromDirectory(config.ZoneDataDirectory)
+	dbs, err := rrdb.NewFromDirectories(config.ZoneDataDirectories())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -31,6 +31,16 @@ func main() {
 	totalOK := 0
 
 	for _, mz := range config.ManagedZones {
+		// private zones are only visible from within their VPC networks
+		if mz.Visibility == "private" {
+			continue
+		}
+		db, err := dbs[mz.ZoneDataDirectory].View(mz.View)
+		if err != nil {
+			log.Printf("%v: %v", mz.FQDN, err)
+			totalNotInDatabase++
+			continue
+		}
 		records, err := db.Zone(mz.FQDN, 0)
 		if err != nil {
 			log.Printf("%v: %v", mz.FQDN, err)
@@ -38,6 +48,11 @@ func main() {
 			continue
 		}
 		for _, record := range records {
+			// the nameservers of delegations to other managed zones are not
+			// known locally
+			if record.RType == "NS" && len(record.RDatas) == 0 {
+				continue
+			}
 			actual, err := lib.Lookup(record.FQDN, record.RType)
 			if err != nil {
 				log.Printf("%v: resolver error", record.FQDN)
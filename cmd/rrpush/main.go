@@ -4,13 +4,15 @@ package main
 import (
 	"flag"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
-	clouddns "google.golang.org/api/dns/v1"
 
+	"github.com/egymgmbh/dns-tools/cmd/internal/push"
 	"github.com/egymgmbh/dns-tools/config"
 	"github.com/egymgmbh/dns-tools/gcp"
+	"github.com/egymgmbh/dns-tools/lib"
 	"github.com/egymgmbh/dns-tools/rrdb"
 )
 
@@ -25,6 +27,14 @@ func main() {
 	gcpSAFile := flag.String("gcp-sa-file", "secret/gcp-sa.json",
 		"Google Cloud Platform Service Account file in JSON format.")
 	noColor := flag.Bool("no-color", false, "Do not colorize output.")
+	timeout := flag.String("timeout", "5m",
+		"Wait at most [timeout] for the changes of a managed zone to be done.")
+	zones := flag.String("zones", "",
+		"Comma-separated list of managed zones to push: FQDNs, IDs, glob "+
+			"patterns, tag:<tag> or label:<key>=<value>. Default: all zones.")
+	onlyNames := flag.String("only-names", "",
+		"Comma-separated list of FQDNs. Only push the records of their "+
+			"subtrees, records outside are left untouched.")
 	flag.Parse()
 
 	// validate flags
@@ -32,10 +42,39 @@ func main() {
 	if err != nil {
 		log.Fatalf("parse delay: %v", err)
 	}
+	timeoutDuration, err := time.ParseDuration(*timeout)
+	if err != nil {
+		log.Fatalf("parse timeout: %v", err)
+	}
+	names := splitList(*onlyNames)
+	for _, name := range names {
+		err = lib.IsValidFQDN(name)
+		if err != nil {
+			log.Fatalf("parse only-names: %v", err)
+		}
+	}
 	config, err := config.New(*configFile)
 	if err != nil {
 		log.Fatalf("load configuration: %v", err)
 	}
+	mzs, err := config.SelectZones(splitList(*zones))
+	if err != nil {
+		log.Fatalf("select managed zones: %v", err)
+	}
+	// a name outside of all selected zones would silently push nothing
+	for _, name := range names {
+		found := false
+		for _, mz := range mzs {
+			if lib.InSubtree(name, mz.FQDN) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Fatalf("parse only-names: %v is not inside any selected "+
+				"managed zone", name)
+		}
+	}
 	service, projectID, err := gcp.GetDNSService(*gcpSAFile, *dryRun)
 	if err != nil {
 		log.Fatalf("get GCP service: %v", err)
@@ -43,7 +82,7 @@ func main() {
 	color.NoColor = *noColor
 
 	// load local data
-	db, err := rrdb.NewFromDirectory(config.ZoneDataDirectory)
+	dbs, err := rrdb.NewFromDirectories(config.ZoneDataDirectories())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -55,7 +94,7 @@ func main() {
 	}
 	gcpManagedZones := make(map[string]string)
 	for _, mz := range gcpMZListResponse.ManagedZones {
-		gcpManagedZones[mz.DnsName] = mz.Name
+		gcpManagedZones[gcp.ManagedZoneID(mz)] = mz.Name
 	}
 
 	// now we walk through the list of locally configured managed zones and
@@ -65,12 +104,15 @@ func main() {
 	totalMissingInDatabase := 0
 	totalMissingOnCloudDNS := 0
 	totalFailed := 0
-	totalDeletions := 0
+	totalPending := 0
 	totalAdditions := 0
-	for _, mz := range config.ManagedZones {
-		log.SetPrefix(mz.FQDN + " ")
+	totalModifications := 0
+	totalDeletions := 0
+	for _, mz := range mzs {
+		log.SetPrefix(mz.ID() + " ")
 		// check zone's availability on Cloud DNS
-		if _, ok := gcpManagedZones[mz.FQDN]; !ok {
+		mzName, ok := gcpManagedZones[mz.ID()]
+		if !ok {
 			color.Set(color.FgHiYellow)
 			log.Printf("Cloud DNS: zone not found")
 			color.Unset()
@@ -80,6 +122,15 @@ func main() {
 		}
 
 		// get zone's records from local database
+		db, err := dbs[mz.ZoneDataDirectory].View(mz.View)
+		if err != nil {
+			color.Set(color.FgHiYellow)
+			log.Printf("local database: %v", err)
+			color.Unset()
+			totalMissingInDatabase++
+			exitOK = false
+			continue
+		}
 		records, err := db.Zone(mz.FQDN, mz.TTL)
 		if err != nil {
 			color.Set(color.FgHiYellow)
@@ -89,10 +140,20 @@ func main() {
 			exitOK = false
 			continue
 		}
+		// delegations to other managed zones point to their nameservers
+		err = gcp.FillDelegations(records, gcpMZListResponse.ManagedZones)
+		if err != nil {
+			color.Set(color.FgHiYellow)
+			log.Printf("Cloud DNS: %v", err)
+			color.Unset()
+			totalFailed++
+			exitOK = false
+			continue
+		}
 
 		// get currently active records from Cloud DNS
 		gcpRRListResponse, err := service.ResourceRecordSets.
-			List(projectID, gcpManagedZones[mz.FQDN]).
+			List(projectID, mzName).
 			Do()
 		if err != nil {
 			color.Set(color.FgHiYellow)
@@ -103,105 +164,93 @@ func main() {
 			continue
 		}
 
-		// We create a change request, which at this time, is very simple:
-		// Delete all current records.
-		// Add all wanted records.
-		change := clouddns.Change{}
-		change.Deletions = gcp.FilterRRSets(gcpRRListResponse.Rrsets, mz.FQDN)
-		change.Additions = gcp.RRDBRecordsToCloudDNSRecords(records)
-		// Usually, most of the records we want on Cloud DNS are already there from
-		// a previous deployment. So we remove this duplicates from change.Deletions
-		// and change.Additions. This leaves us with a nice diff and we only deploy
-		// this diff.
-		gcp.RemoveDuplicatesFromChange(&change)
-
-		// Print the actual change (read: the diff) in a human-friendly way
-		nDeletions := len(change.Deletions)
-		nAdditions := len(change.Additions)
-		if nDeletions == 0 && nAdditions == 0 {
-			log.Println("nothing to change")
+		// the zone's own SOA and NS records are only touched if the
+		// configuration asks for it
+		apexRecords, apexTypes, err := gcp.ApexRecords(gcpRRListResponse.Rrsets, mz)
+		if err != nil {
+			color.Set(color.FgHiYellow)
+			log.Printf("Cloud DNS: apex: %v", err)
+			color.Unset()
+			totalFailed++
+			exitOK = false
 			continue
-		} else {
-			if nDeletions > 0 {
-				log.Printf("%v records to be deleted", nDeletions)
-				color.Set(color.FgRed)
-				for _, line := range gcp.FormatRRSets(change.Deletions) {
-					log.Print(line)
-				}
-				color.Unset()
-			}
-			if nAdditions > 0 {
-				log.Printf("%v records to be added", nAdditions)
-				color.Set(color.FgGreen)
-				for _, line := range gcp.FormatRRSets(change.Additions) {
-					log.Print(line)
-				}
-				color.Unset()
-			}
 		}
+		records = append(records, apexRecords...)
 
-		// enforcing deployment delay
-		if delayDuration > 0 {
-			log.Printf("delaying change for %v seconds...", delayDuration)
-			log.Printf("last chance to abort!")
-			time.Sleep(delayDuration)
-		}
-
-		// back out if this is a dry-run
-		if *dryRun {
+		// Usually, most of the records we want on Cloud DNS are already there from
+		// a previous deployment. So we compare the managed records on Cloud DNS
+		// with the wanted ones RRset by RRset and only deploy the difference.
+		// Records matching the zone's ignore rules are owned by other tools and
+		// are left alone on both sides.
+		want := gcp.RRDBRecordsToCloudDNSRecords(records)
+		for _, rrset := range gcp.IgnoredRRSets(want, mz.Ignore) {
 			color.Set(color.FgHiYellow)
-			log.Printf("skipping action! (dry run)")
+			log.Printf("local database: %v %v: ignored by configuration",
+				rrset.Name, rrset.Type)
 			color.Unset()
-			continue
 		}
+		// Only the selected names are compared, so nothing outside of them is
+		// deleted.
+		have := gcp.SelectRRSets(gcpRRListResponse.Rrsets, names)
+		want = gcp.SelectRRSets(want, names)
+		diff := gcp.DiffRRSets(
+			gcp.FilterRRSets(gcp.WithoutIgnored(have, mz.Ignore), mz.FQDN, apexTypes...),
+			gcp.FilterRRSets(gcp.WithoutIgnored(want, mz.Ignore), mz.FQDN, apexTypes...),
+			gcp.IgnoredRRSets(have, mz.Ignore))
 
-		// uploading change
-		log.Printf("requesting change...")
-		chg := &change
-		chg, err = service.Changes.
-			Create(projectID, gcpManagedZones[mz.FQDN], chg).
-			Do()
+		// Print the actual change (read: the diff) in a human-friendly way and
+		// apply it after the delay, unless this is a dry run
+		applied, err := push.Push(service, projectID, mzName, diff,
+			push.Options{
+				Delay:   delayDuration,
+				DryRun:  *dryRun,
+				Timeout: timeoutDuration,
+			})
+		// update stats
+		totalAdditions += applied.Count(gcp.OpAdd)
+		totalModifications += applied.Count(gcp.OpModifyTTL | gcp.OpModifyRData)
+		totalDeletions += applied.Count(gcp.OpDelete)
 		if err != nil {
 			color.Set(color.FgHiYellow)
 			log.Printf("request failed: %v", err)
 			color.Unset()
-			totalFailed++
-			continue
-		}
-		pollCount := 0
-		for chg.Status == "pending" && pollCount < 30 {
-			log.Println("request pending...")
-			time.Sleep(500 * time.Millisecond)
-			pollCount++
-			chg, err = service.Changes.
-				Get(projectID, gcpManagedZones[mz.FQDN], chg.Id).
-				Do()
-			if err != nil {
-				color.Set(color.FgHiYellow)
-				log.Printf("request failed: %v", err)
-				color.Unset()
-				totalFailed++
-				continue
+			// changes that timed out may still be applied later
+			if batchErr, ok := err.(*gcp.BatchError); ok {
+				if _, ok := batchErr.Err.(*gcp.TimeoutError); ok {
+					totalPending++
+					exitOK = false
+					continue
+				}
 			}
+			totalFailed++
+			exitOK = false
 		}
-		if chg != nil {
-			log.Printf("request status: %v", chg.Status)
-		}
-		// update stats
-		totalDeletions += nDeletions
-		totalAdditions += nAdditions
 	}
 	log.SetPrefix("summary ")
-	log.Printf("%v records removed, %v records created",
-		totalDeletions, totalAdditions)
-	log.Printf("%v managed zones, %v OK, %v failed, "+
+	log.Printf("%v RRsets added, %v modified, %v deleted",
+		totalAdditions, totalModifications, totalDeletions)
+	log.Printf("%v managed zones, %v OK, %v failed, %v pending, "+
 		"%v missing in local database, %v missing on Cloud DNS",
-		len(config.ManagedZones),
-		len(config.ManagedZones)-totalFailed-totalMissingInDatabase-totalMissingOnCloudDNS,
+		len(mzs),
+		len(mzs)-totalFailed-totalPending-
+			totalMissingInDatabase-totalMissingOnCloudDNS,
 		totalFailed,
+		totalPending,
 		totalMissingInDatabase,
 		totalMissingOnCloudDNS)
 	if !exitOK {
 		log.Fatal("some errors occurred")
 	}
 }
+
+// splitList splits a comma-separated list, skipping empty entries
+func splitList(list string) []string {
+	out := []string{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
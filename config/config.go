@@ -3,28 +3,134 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"path"
 	"regexp"
+	"strings"
 
 	"github.com/egymgmbh/dns-tools/lib"
 
-	yaml "gopkg.in/yaml.v2"
+	yaml "gopkg.in/yaml.v3"
 )
 
 var (
 	regexHostname = regexp.MustCompile(`^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])\.$`)
+	regexLabelKey = regexp.MustCompile(`^[a-z][a-z0-9_\-]{0,62}$`)
+	regexLabelVal = regexp.MustCompile(`^[a-z0-9_\-]{0,63}$`)
+	regexRType    = regexp.MustCompile(`^[A-Z][A-Z0-9]*$`)
+
+	// values accepted by Cloud DNS, see
+	// https://cloud.google.com/dns/docs/reference/v1/managedZones
+	dnssecStates        = []string{"off", "on", "transfer"}
+	dnssecAlgorithms    = []string{"rsasha1", "rsasha256", "rsasha512", "ecdsap256sha256", "ecdsap384sha384"}
+	dnssecNonExistences = []string{"nsec", "nsec3"}
+	visibilities        = []string{"public", "private"}
 )
 
-// ManagedZoneDefaults holds the default configuration fo  managed zones
+// DNSSECConfig holds the DNSSEC configuration of a managed zone. An empty
+// state means that DNSSEC is not managed by dns-tools at all.
+type DNSSECConfig struct {
+	State        string
+	Algorithm    string
+	NonExistence string
+}
+
+// SOAConfig holds the values of a managed zone's SOA record. Unset (zero)
+// values are not managed and left untouched on Cloud DNS.
+type SOAConfig struct {
+	TTL     int
+	Refresh int
+	Retry   int
+	Expire  int
+	NegTTL  int
+	Email   string
+}
+
+// IsManaged returns true if at least one SOA value is managed
+func (soa SOAConfig) IsManaged() bool {
+	return soa != SOAConfig{}
+}
+
+// IgnoreRule describes records of a managed zone that are owned by other
+// tools, e.g. the TXT records of ACME challenges. Records matching all set
+// fields of a rule are never touched on Cloud DNS.
+type IgnoreRule struct {
+	Name   string   // regular expression matching the whole FQDN
+	Prefix string   // leading labels of the FQDN, e.g. _acme-challenge
+	Types  []string // record types, any type if empty
+	regex  *regexp.Regexp
+}
+
+// Matches returns true if a record matches the rule. The name of the rule is
+// compiled by New, the names of rules created otherwise are compiled on every
+// call and must be valid.
+func (rule IgnoreRule) Matches(fqdn, rtype string) bool {
+	if len(rule.Types) != 0 && !contains(rule.Types, rtype) {
+		return false
+	}
+	if rule.Prefix != "" && !strings.HasPrefix(strings.ToLower(fqdn),
+		strings.ToLower(strings.TrimSuffix(rule.Prefix, "."))+".") {
+		return false
+	}
+	if rule.Name != "" {
+		regex := rule.regex
+		if regex == nil {
+			regex = regexp.MustCompile(rule.pattern())
+		}
+		if !regex.MatchString(fqdn) {
+			return false
+		}
+	}
+	return true
+}
+
+// pattern returns the regular expression of a rule's name: case-insensitive
+// and matching the whole FQDN
+func (rule IgnoreRule) pattern() string {
+	return `^(?i:` + rule.Name + `)$`
+}
+
+// ManagedZoneDefaults holds the default configuration fo  managed zones. The
+// default ignore rules apply to all managed zones in addition to their own.
 type ManagedZoneDefaults struct {
-	TTL int
+	TTL    int
+	DNSSEC DNSSECConfig
+	SOA    SOAConfig
+	Ignore []IgnoreRule
 }
 
-// ManagedZoneConfig holds a managed zone's configuration
+// ManagedZoneConfig holds a managed zone's configuration. An empty
+// description or nil labels are not managed and left untouched on Cloud DNS.
+// Private zones are only visible to the listed VPC networks and may either
+// forward queries to other nameservers or peer with another VPC network.
 type ManagedZoneConfig struct {
-	FQDN string
-	TTL  int
+	FQDN              string
+	TTL               int
+	Description       string
+	Labels            map[string]string
+	DNSSEC            DNSSECConfig
+	Visibility        string   // public (default) or private
+	Networks          []string // VPC networks a private zone is visible to
+	Forwarding        []string // nameserver addresses of a forwarding zone
+	Peering           string   // target VPC network of a peering zone
+	ZoneDataDirectory string   // defaults to the global zonedata directory
+	View              string   // zonedata view, defaults to the default view
+	SOA               SOAConfig
+	NSTTL             int          // TTL of the zone's own NS record, 0: unmanaged
+	Ignore            []IgnoreRule // records owned by other tools
+	Tags              []string     // select zones in tools, not sent to Cloud DNS
+}
+
+// ID returns a string that identifies a managed zone. A FQDN may exist as
+// both, a public and a private zone.
+func (mz *ManagedZoneConfig) ID() string {
+	if mz.Visibility == "private" {
+		return mz.FQDN + " (private)"
+	}
+	return mz.FQDN
 }
 
 // Config holds the dns-tools configuration
@@ -46,8 +152,10 @@ func New(fname string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = yaml.UnmarshalStrict(data, &yamlConfigData)
-	if err != nil {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&yamlConfigData)
+	if err != nil && err != io.EOF { // EOF: empty document
 		return nil, err
 	}
 	config := yamlConfigData.Config
@@ -57,6 +165,18 @@ func New(fname string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("defaults: %v", err)
 	}
+	err = checkDNSSEC(config.Defaults.DNSSEC)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
+	err = checkSOA(config.Defaults.SOA)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
+	err = checkIgnore(config.Defaults.Ignore)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
 
 	// verify individual managed zones and set default TTL if no individual TTL
 	// configured
@@ -67,25 +187,145 @@ func New(fname string) (*Config, error) {
 		if mz.TTL == 0 {
 			mz.TTL = config.Defaults.TTL
 		}
+		applyDNSSECDefaults(&mz.DNSSEC, config.Defaults.DNSSEC)
+		applySOADefaults(&mz.SOA, config.Defaults.SOA)
+		mz.Ignore = append(mz.Ignore, config.Defaults.Ignore...)
+		if mz.Visibility == "" {
+			mz.Visibility = "public"
+		}
+		if mz.ZoneDataDirectory == "" {
+			mz.ZoneDataDirectory = config.ZoneDataDirectory
+		}
 		// check name
 		err = checkFQDN(mz.FQDN)
 		if err != nil {
-			return nil, fmt.Errorf("managed zone %v: %v", mz.FQDN, err)
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
 		}
 		// check managed zone default TTL
 		err = checkTTL(mz.TTL)
 		if err != nil {
-			return nil, fmt.Errorf("managed zone %v: %v", mz.FQDN, err)
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		// check labels
+		err = checkLabels(mz.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		// check DNSSEC settings
+		err = checkDNSSEC(mz.DNSSEC)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		// check SOA and NS settings
+		err = checkSOA(mz.SOA)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		err = lib.IsValidTTL(mz.NSTTL)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: NS: %v", mz.ID(), err)
+		}
+		// check tags
+		for _, tag := range mz.Tags {
+			if !regexLabelKey.MatchString(tag) {
+				return nil, fmt.Errorf("managed zone %v: invalid tag: %v", mz.ID(), tag)
+			}
+		}
+		// check ignore rules
+		err = checkIgnore(mz.Ignore)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		// check visibility and VPC network bindings
+		err = checkVisibility(mz)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		// check view
+		if mz.View != "" {
+			err = lib.IsValidView(mz.View)
+			if err != nil {
+				return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+			}
 		}
 		// check for duplicate zones
-		if _, ok := seen[mz.FQDN]; ok {
-			return nil, fmt.Errorf("managed zone %v: duplicate entry", mz.FQDN)
+		if _, ok := seen[mz.ID()]; ok {
+			return nil, fmt.Errorf("managed zone %v: duplicate entry", mz.ID())
 		}
-		seen[mz.FQDN] = true
+		seen[mz.ID()] = true
 	}
 	return &config, nil
 }
 
+// ZoneDataDirectories returns the distinct zonedata directories of all
+// managed zones
+func (config *Config) ZoneDataDirectories() []string {
+	directories := []string{}
+	seen := make(map[string]bool)
+	for _, mz := range config.ManagedZones {
+		if seen[mz.ZoneDataDirectory] {
+			continue
+		}
+		seen[mz.ZoneDataDirectory] = true
+		directories = append(directories, mz.ZoneDataDirectory)
+	}
+	return directories
+}
+
+// SelectZones returns the managed zones matching any of the selectors, in
+// configuration order. A selector is either the FQDN or ID of a managed zone,
+// a glob pattern of FQDNs like *.example.com., tag:<tag> or
+// label:<key>=<value>. Every selector has to match at least one managed zone.
+// Without selectors, all managed zones are returned.
+func (config *Config) SelectZones(selectors []string) ([]ManagedZoneConfig, error) {
+	if len(selectors) == 0 {
+		return config.ManagedZones, nil
+	}
+	selected := make([]bool, len(config.ManagedZones))
+	for _, selector := range selectors {
+		found := false
+		for idx := range config.ManagedZones {
+			ok, err := config.ManagedZones[idx].matches(selector)
+			if err != nil {
+				return nil, fmt.Errorf("zone selector %v: %v", selector, err)
+			}
+			if ok {
+				selected[idx] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("zone selector %v: no managed zone matches",
+				selector)
+		}
+	}
+	mzs := []ManagedZoneConfig{}
+	for idx, mz := range config.ManagedZones {
+		if selected[idx] {
+			mzs = append(mzs, mz)
+		}
+	}
+	return mzs, nil
+}
+
+// matches checks if a managed zone matches a selector, see SelectZones
+func (mz *ManagedZoneConfig) matches(selector string) (bool, error) {
+	switch {
+	case strings.HasPrefix(selector, "tag:"):
+		return contains(mz.Tags, strings.TrimPrefix(selector, "tag:")), nil
+	case strings.HasPrefix(selector, "label:"):
+		kv := strings.SplitN(strings.TrimPrefix(selector, "label:"), "=", 2)
+		if len(kv) != 2 {
+			return false, fmt.Errorf("label selector without value")
+		}
+		value, ok := mz.Labels[kv[0]]
+		return ok && value == kv[1], nil
+	case selector == mz.ID():
+		return true, nil
+	}
+	return path.Match(selector, mz.FQDN)
+}
+
 func checkFQDN(fqdn string) error {
 	if !regexHostname.MatchString(fqdn) {
 		return fmt.Errorf("invalid FQDN: %v", fqdn)
@@ -106,3 +346,157 @@ func checkTTL(ttl int) error {
 	}
 	return nil
 }
+
+// applyDNSSECDefaults fills unset DNSSEC values with the configured defaults.
+// If DNSSEC is enabled and still no algorithm or non-existence proof is set,
+// the Cloud DNS defaults are used.
+func applyDNSSECDefaults(dnssec *DNSSECConfig, defaults DNSSECConfig) {
+	if dnssec.State == "" {
+		dnssec.State = defaults.State
+	}
+	if dnssec.Algorithm == "" {
+		dnssec.Algorithm = defaults.Algorithm
+	}
+	if dnssec.NonExistence == "" {
+		dnssec.NonExistence = defaults.NonExistence
+	}
+	if dnssec.State == "" || dnssec.State == "off" {
+		return
+	}
+	if dnssec.Algorithm == "" {
+		dnssec.Algorithm = "rsasha256"
+	}
+	if dnssec.NonExistence == "" {
+		dnssec.NonExistence = "nsec3"
+	}
+}
+
+func checkDNSSEC(dnssec DNSSECConfig) error {
+	if dnssec.State != "" && !contains(dnssecStates, dnssec.State) {
+		return fmt.Errorf("invalid DNSSEC state: %v", dnssec.State)
+	}
+	if dnssec.Algorithm != "" && !contains(dnssecAlgorithms, dnssec.Algorithm) {
+		return fmt.Errorf("invalid DNSSEC algorithm: %v", dnssec.Algorithm)
+	}
+	if dnssec.NonExistence != "" &&
+		!contains(dnssecNonExistences, dnssec.NonExistence) {
+		return fmt.Errorf("invalid DNSSEC non-existence: %v", dnssec.NonExistence)
+	}
+	return nil
+}
+
+// applySOADefaults fills unset SOA values with the configured defaults
+func applySOADefaults(soa *SOAConfig, defaults SOAConfig) {
+	if soa.TTL == 0 {
+		soa.TTL = defaults.TTL
+	}
+	if soa.Refresh == 0 {
+		soa.Refresh = defaults.Refresh
+	}
+	if soa.Retry == 0 {
+		soa.Retry = defaults.Retry
+	}
+	if soa.Expire == 0 {
+		soa.Expire = defaults.Expire
+	}
+	if soa.NegTTL == 0 {
+		soa.NegTTL = defaults.NegTTL
+	}
+	if soa.Email == "" {
+		soa.Email = defaults.Email
+	}
+}
+
+func checkSOA(soa SOAConfig) error {
+	values := []struct {
+		name  string
+		value int
+	}{
+		{"TTL", soa.TTL},
+		{"refresh", soa.Refresh},
+		{"retry", soa.Retry},
+		{"expire", soa.Expire},
+		{"negative TTL", soa.NegTTL},
+	}
+	for _, v := range values {
+		err := lib.IsValidTTL(v.value)
+		if err != nil {
+			return fmt.Errorf("SOA %v: %v", v.name, err)
+		}
+	}
+	if soa.Email != "" {
+		_, err := lib.EmailToRName(soa.Email)
+		if err != nil {
+			return fmt.Errorf("SOA: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkIgnore checks ignore rules and compiles their names
+func checkIgnore(rules []IgnoreRule) error {
+	for idx, rule := range rules {
+		if rule.Name == "" && rule.Prefix == "" && len(rule.Types) == 0 {
+			return fmt.Errorf("ignore rule %v: empty", idx+1)
+		}
+		if rule.Name != "" {
+			regex, err := regexp.Compile(rule.pattern())
+			if err != nil {
+				return fmt.Errorf("ignore rule %v: invalid name: %v", idx+1, err)
+			}
+			rules[idx].regex = regex
+		}
+		for _, rtype := range rule.Types {
+			if !regexRType.MatchString(rtype) {
+				return fmt.Errorf("ignore rule %v: invalid type: %v", idx+1, rtype)
+			}
+		}
+	}
+	return nil
+}
+
+func checkVisibility(mz *ManagedZoneConfig) error {
+	if !contains(visibilities, mz.Visibility) {
+		return fmt.Errorf("invalid visibility: %v", mz.Visibility)
+	}
+	if mz.Visibility == "public" {
+		if len(mz.Networks) > 0 || len(mz.Forwarding) > 0 || mz.Peering != "" {
+			return fmt.Errorf("networks, forwarding and peering require " +
+				"private visibility")
+		}
+		return nil
+	}
+	if len(mz.Networks) == 0 {
+		return fmt.Errorf("private zone without networks")
+	}
+	if len(mz.Forwarding) > 0 && mz.Peering != "" {
+		return fmt.Errorf("forwarding and peering are mutually exclusive")
+	}
+	for _, target := range mz.Forwarding {
+		if lib.IsValidIPv4(target) != nil && lib.IsValidIPv6(target) != nil {
+			return fmt.Errorf("invalid forwarding target: %v", target)
+		}
+	}
+	return nil
+}
+
+func checkLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !regexLabelKey.MatchString(key) {
+			return fmt.Errorf("invalid label key: %v", key)
+		}
+		if !regexLabelVal.MatchString(value) {
+			return fmt.Errorf("invalid label value: %v: %v", key, value)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -121,6 +122,21 @@ func TestNew(t *testing.T) {
 			assert.Equal(t, "managed zone egym.de.: invalid TTL: -1", err.Error())
 		}
 	}
+	{
+		_, err := New("testdata/invalid-ignore.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de.: ignore rule 1: invalid name: "+
+				"error parsing regexp: missing closing ): `^(?i:(k8s)$`", err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/invalid-tag.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de.: invalid tag: Urgent", err.Error())
+		}
+	}
 	{
 		_, err := New("testdata/duplicate-mz.yml")
 		assert.NotEqual(t, nil, err)
@@ -128,7 +144,87 @@ func TestNew(t *testing.T) {
 			assert.Equal(t, "managed zone egym.de.: duplicate entry", err.Error())
 		}
 	}
+	{
+		_, err := New("testdata/invalid-dnssec-state.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de.: invalid DNSSEC state: maybe", err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/invalid-dnssec-algorithm.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "defaults: invalid DNSSEC algorithm: md5", err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/invalid-label.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de.: invalid label key: Team", err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/invalid-public-networks.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de.: networks, forwarding and "+
+				"peering require private visibility", err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/invalid-forwarding.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de. (private): invalid forwarding "+
+				"target: ns1.example.com.", err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/duplicate-private-mz.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de. (private): duplicate entry",
+				err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/invalid-view.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de.: invalid view: Internal",
+				err.Error())
+		}
+	}
+	{
+		_, err := New("testdata/invalid-soa.yml")
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, "managed zone egym.de.: SOA: invalid email address: "+
+				"hostmaster", err.Error())
+		}
+	}
 	// valid configuration
+	{
+		config, err := New("testdata/views.yml")
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, "external", config.ManagedZones[0].View)
+			assert.Equal(t, "internal", config.ManagedZones[1].View)
+		}
+	}
+	{
+		config, err := New("testdata/labels.yml")
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, "Our main zone", config.ManagedZones[0].Description)
+			assert.Equal(t, map[string]string{"team": "sre", "cost-center": "4711"},
+				config.ManagedZones[0].Labels)
+			assert.Equal(t, "", config.ManagedZones[1].Description)
+			assert.Equal(t, map[string]string(nil), config.ManagedZones[1].Labels)
+		}
+	}
 	{
 		config, err := New("testdata/complete.yml")
 		assert.Equal(t, nil, err)
@@ -142,3 +238,138 @@ func TestNew(t *testing.T) {
 		}
 	}
 }
+
+func TestNewDNSSEC(t *testing.T) {
+	config, err := New("testdata/dnssec.yml")
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	// defaults apply to enabled zones only
+	assert.Equal(t, DNSSECConfig{
+		State:        "on",
+		Algorithm:    "ecdsap256sha256",
+		NonExistence: "nsec3",
+	}, config.ManagedZones[0].DNSSEC)
+	// individual settings win over defaults
+	assert.Equal(t, DNSSECConfig{
+		State:        "on",
+		Algorithm:    "rsasha256",
+		NonExistence: "nsec",
+	}, config.ManagedZones[1].DNSSEC)
+	// no state means DNSSEC is not managed
+	assert.Equal(t, "", config.ManagedZones[2].DNSSEC.State)
+}
+
+func TestNewPrivate(t *testing.T) {
+	config, err := New("testdata/private.yml")
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	assert.Equal(t, "public", config.ManagedZones[0].Visibility)
+	assert.Equal(t, "egym.de.", config.ManagedZones[0].ID())
+	assert.Equal(t, "zonedata/", config.ManagedZones[0].ZoneDataDirectory)
+	assert.Equal(t, "private", config.ManagedZones[1].Visibility)
+	assert.Equal(t, "egym.de. (private)", config.ManagedZones[1].ID())
+	assert.Equal(t, "zonedata-private/", config.ManagedZones[1].ZoneDataDirectory)
+	assert.Equal(t, []string{"10.0.0.53", "2001:db8::53"},
+		config.ManagedZones[2].Forwarding)
+	assert.Equal(t, []string{"zonedata/", "zonedata-private/"},
+		config.ZoneDataDirectories())
+}
+
+func TestNewSOA(t *testing.T) {
+	config, err := New("testdata/soa.yml")
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	assert.Equal(t, SOAConfig{
+		TTL:     3600,
+		Refresh: 3600,
+		Retry:   300,
+		Expire:  7200,
+		NegTTL:  300,
+		Email:   "hostmaster@egym.de",
+	}, config.ManagedZones[0].SOA)
+	assert.Equal(t, 86400, config.ManagedZones[0].NSTTL)
+	assert.Equal(t, SOAConfig{
+		TTL:     3600,
+		Refresh: 3600,
+		Retry:   300,
+		Expire:  7200,
+		NegTTL:  60,
+		Email:   "dns@egym.com",
+	}, config.ManagedZones[1].SOA)
+	assert.Equal(t, 0, config.ManagedZones[1].NSTTL)
+	assert.Equal(t, true, config.ManagedZones[1].SOA.IsManaged())
+	assert.Equal(t, false, SOAConfig{}.IsManaged())
+}
+
+func TestNewIgnore(t *testing.T) {
+	config, err := New("testdata/ignore.yml")
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	acme := IgnoreRule{Prefix: "_acme-challenge", Types: []string{"TXT"}}
+	k8s := config.ManagedZones[0].Ignore[0]
+	assert.Equal(t, `.*\.k8s\.egym\.de\.`, k8s.Name)
+	assert.Equal(t, []string{"A", "TXT"}, k8s.Types)
+	assert.NotEqual(t, (*regexp.Regexp)(nil), k8s.regex)
+	assert.Equal(t, []IgnoreRule{k8s, acme}, config.ManagedZones[0].Ignore)
+	assert.Equal(t, []IgnoreRule{acme}, config.ManagedZones[1].Ignore)
+
+	assert.Equal(t, true, acme.Matches("_acme-challenge.www.egym.de.", "TXT"))
+	assert.Equal(t, true, acme.Matches("_ACME-challenge.egym.de.", "TXT"))
+	assert.Equal(t, false, acme.Matches("_acme-challenge.egym.de.", "CNAME"))
+	assert.Equal(t, false, acme.Matches("x_acme-challenge.egym.de.", "TXT"))
+	assert.Equal(t, true, k8s.Matches("api.K8S.egym.de.", "A"))
+	assert.Equal(t, false, k8s.Matches("api.k8s.egym.de.", "AAAA"))
+	assert.Equal(t, false, k8s.Matches("k8s.egym.de.", "A"))
+	// rules not loaded by New
+	assert.Equal(t, true, IgnoreRule{Name: k8s.Name}.Matches("api.k8s.egym.de.", "AAAA"))
+}
+
+func TestCheckIgnore(t *testing.T) {
+	assert.Equal(t, nil, checkIgnore(nil))
+	assert.Equal(t, "ignore rule 2: empty",
+		checkIgnore([]IgnoreRule{{Prefix: "_acme-challenge"}, {}}).Error())
+	assert.Equal(t, "ignore rule 1: invalid type: txt",
+		checkIgnore([]IgnoreRule{{Types: []string{"txt"}}}).Error())
+}
+
+func TestSelectZones(t *testing.T) {
+	config, err := New("testdata/tags.yml")
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	ids := func(selectors ...string) []string {
+		mzs, err := config.SelectZones(selectors)
+		assert.Equal(t, nil, err)
+		out := []string{}
+		for _, mz := range mzs {
+			out = append(out, mz.ID())
+		}
+		return out
+	}
+	assert.Equal(t, []string{"egym.de.", "egym.de. (private)", "shop.egym.de.",
+		"egym.com."}, ids())
+	assert.Equal(t, []string{"egym.de.", "egym.de. (private)"}, ids("egym.de."))
+	assert.Equal(t, []string{"egym.de. (private)"}, ids("egym.de. (private)"))
+	assert.Equal(t, []string{"shop.egym.de."}, ids("*.egym.de."))
+	assert.Equal(t, []string{"egym.de.", "shop.egym.de."}, ids("tag:urgent"))
+	assert.Equal(t, []string{"egym.de. (private)", "shop.egym.de.", "egym.com."},
+		ids("tag:internal", "egym.com."))
+	assert.Equal(t, []string{"egym.de."}, ids("label:team=sre"))
+
+	_, err = config.SelectZones([]string{"egym.org."})
+	assert.Equal(t, "zone selector egym.org.: no managed zone matches", err.Error())
+	_, err = config.SelectZones([]string{"label:team"})
+	assert.Equal(t, "zone selector label:team: label selector without value",
+		err.Error())
+	_, err = config.SelectZones([]string{"[egym"})
+	assert.Equal(t, "zone selector [egym: syntax error in pattern", err.Error())
+}
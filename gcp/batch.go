@@ -0,0 +1,159 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	clouddns "google.golang.org/api/dns/v1"
+)
+
+// MaxChangeSize is the maximum number of additions and of deletions Cloud DNS
+// accepts in a single change
+const MaxChangeSize = 1000
+
+// Batches splits a diff into changes holding at most size additions and at
+// most size deletions each. All operations on a name go into the same change,
+// which Cloud DNS applies atomically, so no name is ever left in a conflicting
+// state, e.g. with a CNAME record next to an A record. Changes are ordered like
+// the diff.
+func (diff Diff) Batches(size int) []*clouddns.Change {
+	batches := []*clouddns.Change{}
+	for start := 0; start < len(diff); {
+		// operations on the same name
+		end := start + 1
+		for end < len(diff) &&
+			strings.EqualFold(diff[end].Name, diff[start].Name) {
+			end++
+		}
+		group := diff[start:end].Change()
+		start = end
+		if len(group.Deletions) == 0 && len(group.Additions) == 0 {
+			continue // unmanaged only
+		}
+
+		if len(batches) != 0 {
+			last := batches[len(batches)-1]
+			if len(last.Deletions)+len(group.Deletions) <= size &&
+				len(last.Additions)+len(group.Additions) <= size {
+				last.Deletions = append(last.Deletions, group.Deletions...)
+				last.Additions = append(last.Additions, group.Additions...)
+				continue
+			}
+		}
+		batches = append(batches, group)
+	}
+	return batches
+}
+
+// Applied returns the operations of a diff that are part of the first n of
+// its batches, e.g. the ones applied before a *BatchError. Unmanaged resource
+// record sets are left out.
+func (diff Diff) Applied(batches []*clouddns.Change, n int) Diff {
+	if n > len(batches) {
+		n = len(batches)
+	}
+	names := make(map[string]bool)
+	for _, batch := range batches[:n] {
+		for _, rrset := range batch.Deletions {
+			names[strings.ToLower(rrset.Name)] = true
+		}
+		for _, rrset := range batch.Additions {
+			names[strings.ToLower(rrset.Name)] = true
+		}
+	}
+	applied := Diff{}
+	for _, op := range diff {
+		if op.Kind != OpUnmanaged && names[strings.ToLower(op.Name)] {
+			applied = append(applied, op)
+		}
+	}
+	return applied
+}
+
+// BatchError describes the failure of a batch of changes, some of which may
+// have been applied already
+type BatchError struct {
+	Applied int // number of changes applied before the failure
+	Total   int
+	Err     error
+}
+
+// Error implements the error interface
+func (err *BatchError) Error() string {
+	return fmt.Sprintf("change %v of %v: %v (%v of %v changes applied)",
+		err.Applied+1, err.Total, err.Err, err.Applied, err.Total)
+}
+
+// ApplyBatches applies changes to a managed zone one after another, waiting
+// for each change to be done before requesting the next one (see
+// WaitForChange). If a change fails, the remaining changes are skipped and a
+// *BatchError reports how far the batches got.
+func ApplyBatches(ctx context.Context, service *clouddns.Service, projectID, mzName string, batches []*clouddns.Change) error {
+	for idx, batch := range batches {
+		chg, err := service.Changes.Create(projectID, mzName, batch).
+			Context(ctx).
+			Do()
+		if err == nil {
+			_, err = WaitForChange(ctx, service, projectID, mzName, chg)
+		}
+		if err != nil {
+			return &BatchError{Applied: idx, Total: len(batches), Err: err}
+		}
+	}
+	return nil
+}
+
+// intervals between polls of a change's status
+var (
+	pollInterval    = 500 * time.Millisecond
+	maxPollInterval = 10 * time.Second
+)
+
+// TimeoutError describes a change that was not done before the deadline. The
+// change may still be applied later.
+type TimeoutError struct {
+	ID     string
+	Status string
+}
+
+// Error implements the error interface
+func (err *TimeoutError) Error() string {
+	return fmt.Sprintf("change %v: timed out waiting for completion, status %v",
+		err.ID, err.Status)
+}
+
+// WaitForChange polls a change until it is done, doubling the interval
+// between polls up to a maximum. It returns the final state of the change. If
+// the context is done before the change, a *TimeoutError is returned.
+func WaitForChange(ctx context.Context, service *clouddns.Service, projectID, mzName string, chg *clouddns.Change) (*clouddns.Change, error) {
+	interval := pollInterval
+	for chg.Status == "pending" {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return chg, &TimeoutError{ID: chg.Id, Status: chg.Status}
+		case <-timer.C:
+		}
+		next, err := service.Changes.Get(projectID, mzName, chg.Id).
+			Context(ctx).
+			Do()
+		if err != nil {
+			if ctx.Err() != nil {
+				return chg, &TimeoutError{ID: chg.Id, Status: chg.Status}
+			}
+			return chg, fmt.Errorf("change %v: %v", chg.Id, err)
+		}
+		chg = next
+		interval *= 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+	if chg.Status != "done" {
+		return chg, fmt.Errorf("change %v: status %v", chg.Id, chg.Status)
+	}
+	return chg, nil
+}
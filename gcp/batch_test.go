@@ -0,0 +1,177 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clouddns "google.golang.org/api/dns/v1"
+)
+
+func TestBatches(t *testing.T) {
+	have := []*clouddns.ResourceRecordSet{
+		testRRSet("a.example.com.", "CNAME", 300, "www.example.com."),
+		testRRSet("b.example.com.", "A", 300, "192.0.2.1"),
+		testRRSet("c.example.com.", "A", 300, "192.0.2.1"),
+	}
+	want := []*clouddns.ResourceRecordSet{
+		testRRSet("a.example.com.", "A", 300, "192.0.2.1"),
+		testRRSet("a.example.com.", "AAAA", 300, "2001:db8::1"),
+		testRRSet("b.example.com.", "A", 300, "192.0.2.2"),
+		testRRSet("d.example.com.", "A", 300, "192.0.2.1"),
+	}
+	diff := DiffRRSets(have, want, nil)
+
+	// everything fits into a single change
+	batches := diff.Batches(MaxChangeSize)
+	assert.Equal(t, []*clouddns.Change{diff.Change()}, batches)
+
+	// operations on the same name are never split
+	batches = diff.Batches(2)
+	assert.Equal(t, []*clouddns.Change{
+		{
+			Deletions: []*clouddns.ResourceRecordSet{have[0]},
+			Additions: []*clouddns.ResourceRecordSet{want[0], want[1]},
+		},
+		{
+			Deletions: []*clouddns.ResourceRecordSet{have[1], have[2]},
+			Additions: []*clouddns.ResourceRecordSet{want[2], want[3]},
+		},
+	}, batches)
+
+	// names exceeding the size get a change of their own
+	batches = diff.Batches(1)
+	assert.Equal(t, 3, len(batches))
+	assert.Equal(t, []*clouddns.ResourceRecordSet{want[0], want[1]},
+		batches[0].Additions)
+
+	assert.Equal(t, []*clouddns.Change{}, Diff{}.Batches(MaxChangeSize))
+
+	// operations of applied batches
+	applied := diff.Applied(batches, 2)
+	names := []string{}
+	for _, op := range applied {
+		names = append(names, op.Name+" "+op.Type)
+	}
+	assert.Equal(t, []string{
+		"a.example.com. A",
+		"a.example.com. CNAME",
+		"a.example.com. AAAA",
+		"b.example.com. A",
+	}, names)
+	assert.Equal(t, 1, applied.Count(OpModifyRData))
+	assert.Equal(t, Diff{}, diff.Applied(batches, 0))
+	assert.Equal(t, diff, diff.Applied(batches, 3))
+	// unmanaged resource record sets are never applied
+	unmanaged := DiffRRSets(nil, nil, have[:1])
+	assert.Equal(t, Diff{}, unmanaged.Applied(diff.Batches(1), 3))
+}
+
+// fakeChanges serves the changes API of Cloud DNS, failing the change with a
+// given number. Changes stay pending for a number of polls, forever if
+// negative.
+func fakeChanges(t *testing.T, fail, pending int) (*clouddns.Service, *[]*clouddns.Change, func()) {
+	created := []*clouddns.Change{}
+	polls := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/changes"):
+			chg := &clouddns.Change{}
+			assert.Equal(t, nil, json.NewDecoder(r.Body).Decode(chg))
+			if len(created)+1 == fail {
+				http.Error(w, `{"error": {"code": 400, "message": "bad change"}}`,
+					http.StatusBadRequest)
+				return
+			}
+			created = append(created, chg)
+			chg.Id = fmt.Sprint(len(created))
+			chg.Status = "pending"
+			_ = json.NewEncoder(w).Encode(chg)
+		case r.Method == "GET" && strings.Contains(r.URL.Path, "/changes/"):
+			id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			polls[id]++
+			status := "done"
+			if pending < 0 || polls[id] <= pending {
+				status = "pending"
+			}
+			_ = json.NewEncoder(w).Encode(&clouddns.Change{Id: id, Status: status})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	service, err := clouddns.New(server.Client())
+	assert.Equal(t, nil, err)
+	service.BasePath = server.URL + "/"
+	return service, &created, server.Close
+}
+
+func TestApplyBatches(t *testing.T) {
+	// poll fast
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+	batches := []*clouddns.Change{
+		{Additions: []*clouddns.ResourceRecordSet{
+			testRRSet("a.example.com.", "A", 300, "192.0.2.1")}},
+		{Additions: []*clouddns.ResourceRecordSet{
+			testRRSet("b.example.com.", "A", 300, "192.0.2.1")}},
+		{Additions: []*clouddns.ResourceRecordSet{
+			testRRSet("c.example.com.", "A", 300, "192.0.2.1")}},
+	}
+	{
+		service, created, done := fakeChanges(t, 0, 0)
+		defer done()
+		assert.Equal(t, nil, ApplyBatches(context.Background(), service, "project", "zone", batches))
+		assert.Equal(t, 3, len(*created))
+	}
+	// partial progress
+	{
+		service, created, done := fakeChanges(t, 2, 0)
+		defer done()
+		err := ApplyBatches(context.Background(), service, "project", "zone", batches)
+		batchErr, ok := err.(*BatchError)
+		assert.Equal(t, true, ok)
+		if ok {
+			assert.Equal(t, 1, batchErr.Applied)
+			assert.Equal(t, 3, batchErr.Total)
+			assert.Equal(t, true, strings.HasPrefix(err.Error(), "change 2 of 3: "))
+			assert.Equal(t, true, strings.HasSuffix(err.Error(),
+				"(1 of 3 changes applied)"))
+		}
+		assert.Equal(t, 1, len(*created))
+	}
+}
+
+func TestWaitForChange(t *testing.T) {
+	// poll fast
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+	service, _, done := fakeChanges(t, 0, 3)
+	defer done()
+	chg, err := WaitForChange(context.Background(), service, "project", "zone",
+		&clouddns.Change{Id: "1", Status: "pending"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "done", chg.Status)
+
+	// changes that are done already are not polled
+	chg, err = WaitForChange(context.Background(), nil, "project", "zone",
+		&clouddns.Change{Id: "2", Status: "done"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "done", chg.Status)
+
+	// deadline exceeded
+	service, _, done = fakeChanges(t, 0, -1)
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = WaitForChange(ctx, service, "project", "zone",
+		&clouddns.Change{Id: "3", Status: "pending"})
+	assert.Equal(t, &TimeoutError{ID: "3", Status: "pending"}, err)
+	assert.Equal(t, "change 3: timed out waiting for completion, status pending",
+		err.Error())
+}
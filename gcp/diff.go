@@ -0,0 +1,215 @@
+package gcp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	clouddns "google.golang.org/api/dns/v1"
+
+	"github.com/egymgmbh/dns-tools/lib"
+	"github.com/egymgmbh/dns-tools/rrdb"
+)
+
+// OpKind describes what an operation of a diff does to a resource record set.
+// The kinds of modifications can be combined. Unmanaged resource record sets
+// are owned by other tools and only reported, they are never changed.
+type OpKind int
+
+// kinds of operations
+const (
+	OpAdd OpKind = 1 << iota
+	OpDelete
+	OpModifyTTL
+	OpModifyRData
+	OpUnmanaged
+)
+
+// opChanges combines all kinds of operations that change resource record sets
+const opChanges = OpAdd | OpDelete | OpModifyTTL | OpModifyRData
+
+// String returns the human readable name of an operation kind, e.g.
+// "modify TTL, modify rdata"
+func (kind OpKind) String() string {
+	names := []string{}
+	for _, op := range []struct {
+		kind OpKind
+		name string
+	}{
+		{OpAdd, "add"},
+		{OpDelete, "delete"},
+		{OpModifyTTL, "modify TTL"},
+		{OpModifyRData, "modify rdata"},
+		{OpUnmanaged, "unmanaged"},
+	} {
+		if kind&op.kind != 0 {
+			names = append(names, op.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Operation describes the change of a single resource record set, identified
+// by its name and type. Before is nil for additions, After is nil for
+// deletions and unmanaged resource record sets.
+type Operation struct {
+	Kind   OpKind
+	Name   string
+	Type   string
+	Before *clouddns.ResourceRecordSet
+	After  *clouddns.ResourceRecordSet
+}
+
+// Diff is a list of operations in canonical order of the resource record sets
+// they change
+type Diff []Operation
+
+// DiffRRSets compares the resource record sets currently on Cloud DNS with the
+// wanted ones and returns the operations needed to get from one to the other.
+// Resource record sets are identified by name and type, their data is compared
+// in canonical form (see rrdb.CanonicalRData). Resource record sets that do
+// not change are left out. The unmanaged resource record sets on Cloud DNS,
+// see IgnoredRRSets, are reported as such.
+func DiffRRSets(have, want, unmanaged []*clouddns.ResourceRecordSet) Diff {
+	key := func(rrset *clouddns.ResourceRecordSet) string {
+		return strings.ToLower(rrset.Name) + " " + rrset.Type
+	}
+	haveByKey := make(map[string]*clouddns.ResourceRecordSet)
+	for _, rrset := range have {
+		haveByKey[key(rrset)] = rrset
+	}
+	diff := Diff{}
+	seen := make(map[string]bool)
+	for _, after := range want {
+		seen[key(after)] = true
+		before, ok := haveByKey[key(after)]
+		if !ok {
+			diff = append(diff, Operation{Kind: OpAdd, Name: after.Name,
+				Type: after.Type, After: after})
+			continue
+		}
+		var kind OpKind
+		if before.Ttl != after.Ttl {
+			kind |= OpModifyTTL
+		}
+		if !reflect.DeepEqual(rrdb.CanonicalRDatas(before.Type, before.Rrdatas),
+			rrdb.CanonicalRDatas(after.Type, after.Rrdatas)) {
+			kind |= OpModifyRData
+		}
+		if kind != 0 {
+			diff = append(diff, Operation{Kind: kind, Name: after.Name,
+				Type: after.Type, Before: before, After: after})
+		}
+	}
+	for _, before := range have {
+		if !seen[key(before)] {
+			diff = append(diff, Operation{Kind: OpDelete, Name: before.Name,
+				Type: before.Type, Before: before})
+		}
+	}
+	for _, rrset := range unmanaged {
+		diff = append(diff, Operation{Kind: OpUnmanaged, Name: rrset.Name,
+			Type: rrset.Type, Before: rrset})
+	}
+	diff.sort()
+	return diff
+}
+
+func (diff Diff) sort() {
+	sort.SliceStable(diff, func(i, j int) bool {
+		if c := lib.CompareNames(diff[i].Name, diff[j].Name); c != 0 {
+			return c < 0
+		}
+		return lib.CompareRTypes(diff[i].Type, diff[j].Type) < 0
+	})
+}
+
+// Change converts a diff to a Cloud DNS change. Modifications delete the
+// resource record set as it is on Cloud DNS and add the wanted one. Unmanaged
+// resource record sets are left out.
+func (diff Diff) Change() *clouddns.Change {
+	change := &clouddns.Change{
+		Deletions: []*clouddns.ResourceRecordSet{},
+		Additions: []*clouddns.ResourceRecordSet{},
+	}
+	for _, op := range diff {
+		if op.Kind == OpUnmanaged {
+			continue
+		}
+		if op.Before != nil {
+			change.Deletions = append(change.Deletions, op.Before)
+		}
+		if op.After != nil {
+			change.Additions = append(change.Additions, op.After)
+		}
+	}
+	return change
+}
+
+// Count returns the number of operations of a kind, e.g. Count(OpModifyTTL)
+// counts all operations modifying the TTL, including the ones modifying the
+// data as well
+func (diff Diff) Count(kind OpKind) int {
+	count := 0
+	for _, op := range diff {
+		if op.Kind&kind != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// HasChanges returns true if a diff changes any resource record set
+func (diff Diff) HasChanges() bool {
+	return diff.Count(opChanges) != 0
+}
+
+// Format renders a diff in a unified diff style. Every operation starts with
+// a header line "@@ name type @@ kind", followed by the records before (-) and
+// after (+) the change. Records not changed by a modification of the data and
+// unmanaged records are shown as context (space).
+func (diff Diff) Format() []string {
+	out := []string{}
+	line := func(prefix string, rrset *clouddns.ResourceRecordSet, rdata string) {
+		out = append(out, fmt.Sprintf("%v%v %v IN %v %v", prefix, rrset.Name,
+			rrset.Ttl, rrset.Type, rdata))
+	}
+	for _, op := range diff {
+		out = append(out, fmt.Sprintf("@@ %v %v @@ %v", op.Name, op.Type, op.Kind))
+		var before, after []string
+		if op.Before != nil {
+			before = rrdb.CanonicalRDatas(op.Type, op.Before.Rrdatas)
+		}
+		if op.After != nil {
+			after = rrdb.CanonicalRDatas(op.Type, op.After.Rrdatas)
+		}
+		// unchanged data is only context if the TTL stays the same
+		context := make(map[string]bool)
+		switch op.Kind {
+		case OpUnmanaged:
+			for _, rdata := range before {
+				context[rdata] = true
+			}
+		case OpModifyRData:
+			for _, rdata := range after {
+				context[rdata] = true
+			}
+		}
+		kept := make(map[string]bool)
+		for _, rdata := range before {
+			if context[rdata] {
+				line(" ", op.Before, rdata)
+				kept[rdata] = true
+				continue
+			}
+			line("-", op.Before, rdata)
+		}
+		for _, rdata := range after {
+			if !kept[rdata] {
+				line("+", op.After, rdata)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	clouddns "google.golang.org/api/dns/v1"
+)
+
+func testRRSet(name, rtype string, ttl int64, rdatas ...string) *clouddns.ResourceRecordSet {
+	return &clouddns.ResourceRecordSet{
+		Kind:    "dns#resourceRecordSet",
+		Name:    name,
+		Type:    rtype,
+		Ttl:     ttl,
+		Rrdatas: rdatas,
+	}
+}
+
+func TestOpKind(t *testing.T) {
+	assert.Equal(t, "add", OpAdd.String())
+	assert.Equal(t, "modify TTL, modify rdata", (OpModifyTTL | OpModifyRData).String())
+	assert.Equal(t, "unmanaged", OpUnmanaged.String())
+}
+
+func TestDiffRRSets(t *testing.T) {
+	have := []*clouddns.ResourceRecordSet{
+		testRRSet("www.example.com.", "A", 300, "192.0.2.1", "192.0.2.2"),
+		testRRSet("api.example.com.", "AAAA", 300, "2001:DB8::1"),
+		testRRSet("old.example.com.", "CNAME", 300, "www.example.com."),
+		testRRSet("example.com.", "MX", 300, "10 mx.example.com."),
+		testRRSet("example.com.", "TXT", 300, `"v=spf1 -all"`),
+	}
+	want := []*clouddns.ResourceRecordSet{
+		testRRSet("example.com.", "TXT", 300, `"v=spf1 mx -all"`),
+		testRRSet("example.com.", "MX", 600, "10 mx.example.com."),
+		testRRSet("api.example.com.", "AAAA", 300, "2001:db8::1"),
+		testRRSet("www.example.com.", "A", 300, "192.0.2.3", "192.0.2.1"),
+		testRRSet("new.example.com.", "A", 60, "192.0.2.4"),
+		testRRSet("example.com.", "A", 60, "192.0.2.4"),
+	}
+	diff := DiffRRSets(have, want, nil)
+	kinds := []string{}
+	for _, op := range diff {
+		kinds = append(kinds, op.Name+" "+op.Type+": "+op.Kind.String())
+	}
+	assert.Equal(t, []string{
+		"example.com. A: add",
+		"example.com. MX: modify TTL",
+		"example.com. TXT: modify rdata",
+		"new.example.com. A: add",
+		"old.example.com. CNAME: delete",
+		"www.example.com. A: modify rdata",
+	}, kinds)
+	assert.Equal(t, 2, diff.Count(OpAdd))
+	assert.Equal(t, 3, diff.Count(OpModifyTTL|OpModifyRData))
+
+	change := diff.Change()
+	assert.Equal(t, []*clouddns.ResourceRecordSet{have[3], have[4], have[2],
+		have[0]}, change.Deletions)
+	assert.Equal(t, []*clouddns.ResourceRecordSet{want[5], want[1], want[0],
+		want[4], want[3]}, change.Additions)
+
+	assert.Equal(t, []string{
+		"@@ example.com. A @@ add",
+		"+example.com. 60 IN A 192.0.2.4",
+		"@@ example.com. MX @@ modify TTL",
+		"-example.com. 300 IN MX 10 mx.example.com.",
+		"+example.com. 600 IN MX 10 mx.example.com.",
+		"@@ example.com. TXT @@ modify rdata",
+		`-example.com. 300 IN TXT "v=spf1 -all"`,
+		`+example.com. 300 IN TXT "v=spf1 mx -all"`,
+		"@@ new.example.com. A @@ add",
+		"+new.example.com. 60 IN A 192.0.2.4",
+		"@@ old.example.com. CNAME @@ delete",
+		"-old.example.com. 300 IN CNAME www.example.com.",
+		"@@ www.example.com. A @@ modify rdata",
+		" www.example.com. 300 IN A 192.0.2.1",
+		"-www.example.com. 300 IN A 192.0.2.2",
+		"+www.example.com. 300 IN A 192.0.2.3",
+	}, diff.Format())
+
+	// nothing to do
+	diff = DiffRRSets(have, have, nil)
+	assert.Equal(t, Diff{}, diff)
+	assert.Equal(t, &clouddns.Change{
+		Deletions: []*clouddns.ResourceRecordSet{},
+		Additions: []*clouddns.ResourceRecordSet{},
+	}, diff.Change())
+}
+
+func TestDiffRRSetsUnmanaged(t *testing.T) {
+	acme := testRRSet("_acme-challenge.example.com.", "TXT", 60, `"token"`)
+	have := []*clouddns.ResourceRecordSet{
+		testRRSet("example.com.", "A", 300, "192.0.2.1"),
+	}
+	diff := DiffRRSets(have, have, []*clouddns.ResourceRecordSet{acme})
+	assert.Equal(t, false, diff.HasChanges())
+	assert.Equal(t, 1, diff.Count(OpUnmanaged))
+	assert.Equal(t, []string{
+		"@@ _acme-challenge.example.com. TXT @@ unmanaged",
+		` _acme-challenge.example.com. 60 IN TXT "token"`,
+	}, diff.Format())
+	assert.Equal(t, &clouddns.Change{
+		Deletions: []*clouddns.ResourceRecordSet{},
+		Additions: []*clouddns.ResourceRecordSet{},
+	}, diff.Change())
+	assert.Equal(t, []*clouddns.Change{}, diff.Batches(MaxChangeSize))
+
+	diff = DiffRRSets(have, nil, []*clouddns.ResourceRecordSet{acme})
+	assert.Equal(t, true, diff.HasChanges())
+	assert.Equal(t, []*clouddns.ResourceRecordSet{have[0]},
+		diff.Change().Deletions)
+}
@@ -8,13 +8,36 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"sort"
+	"strings"
 
 	"golang.org/x/oauth2/google"
 	clouddns "google.golang.org/api/dns/v1"
 
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/lib"
 	"github.com/egymgmbh/dns-tools/rrdb"
 )
 
+var (
+	// DNSSEC algorithm numbers, see
+	// https://www.iana.org/assignments/dns-sec-alg-numbers
+	dnssecAlgorithmNumbers = map[string]int{
+		"rsasha1":         5,
+		"rsasha256":       8,
+		"rsasha512":       10,
+		"ecdsap256sha256": 13,
+		"ecdsap384sha384": 14,
+	}
+	// DS digest type numbers, see
+	// https://www.iana.org/assignments/ds-rr-types
+	dsDigestTypeNumbers = map[string]int{
+		"sha1":   1,
+		"sha256": 2,
+		"sha384": 4,
+	}
+)
+
 // GetDNSService creates a CloudDNS API service from a service account file
 func GetDNSService(gcpSAFile string, readonly bool) (*clouddns.Service, string, error) {
 	// read and parse Service Account file
@@ -73,85 +96,509 @@ func RRDBRecordsToCloudDNSRecords(in []*rrdb.Record) []*clouddns.ResourceRecordS
 	return out
 }
 
-func removeNilPointersFromRRS(in []*clouddns.ResourceRecordSet) []*clouddns.ResourceRecordSet {
-	out := []*clouddns.ResourceRecordSet{}
-	for _, item := range in {
-		if item == nil {
+// SortRRSets sorts resource record sets in canonical order like
+// rrdb.SortRecords: by name in canonical DNS name order, then by record type
+// number. The data of each resource record set is sorted as well, see
+// rrdb.SortRDatas.
+func SortRRSets(rrsets []*clouddns.ResourceRecordSet) {
+	for _, rrset := range rrsets {
+		rrdb.SortRDatas(rrset.Type, rrset.Rrdatas)
+	}
+	sort.SliceStable(rrsets, func(i, j int) bool {
+		if c := lib.CompareNames(rrsets[i].Name, rrsets[j].Name); c != 0 {
+			return c < 0
+		}
+		return lib.CompareRTypes(rrsets[i].Type, rrsets[j].Type) < 0
+	})
+}
+
+// FilterRRSets removes resource record sets that must not be managed by
+// dns-tools from a list. This is a safeguard. The zone's own SOA and NS records
+// are only kept if their types are explicitly listed as managed apex types.
+// Resource record sets owned by other tools are removed by WithoutIgnored.
+func FilterRRSets(rrsets []*clouddns.ResourceRecordSet, fqdn string, apexTypes ...string) []*clouddns.ResourceRecordSet {
+	managedApex := make(map[string]bool)
+	for _, rtype := range apexTypes {
+		managedApex[rtype] = true
+	}
+	filtered := []*clouddns.ResourceRecordSet{}
+	for _, rr := range rrsets {
+		// ignore everything that is not a ResourceRecordSet
+		if rr.Kind != "dns#resourceRecordSet" {
 			continue
 		}
-		out = append(out, item)
+		// don't even look at SOA and NS records for the zone itself unless
+		// they are explicitly managed
+		if (rr.Type == "SOA" || rr.Type == "NS") && rr.Name == fqdn {
+			if managedApex[rr.Type] {
+				filtered = append(filtered, rr)
+			}
+			continue
+		}
+		// check everything else
+		if rr.Type == "NS" || rr.Type == "MX" || rr.Type == "TXT" || rr.Type == "CNAME" ||
+			rr.Type == "A" || rr.Type == "AAAA" {
+			filtered = append(filtered, rr)
+		}
+	}
+	return filtered
+}
+
+// IgnoredRRSets returns the resource record sets of a list that match any of
+// the ignore rules of a managed zone
+func IgnoredRRSets(rrsets []*clouddns.ResourceRecordSet, ignore []config.IgnoreRule) []*clouddns.ResourceRecordSet {
+	out := []*clouddns.ResourceRecordSet{}
+	for _, rr := range rrsets {
+		if rr.Kind == "dns#resourceRecordSet" && ignored(rr, ignore) {
+			out = append(out, rr)
+		}
 	}
 	return out
 }
 
-// recordID returns a unique ID for a ResourceRecordSet to be used as key in
-// hash maps
-func recordID(record *clouddns.ResourceRecordSet) string {
-	return fmt.Sprintf("%v|%v|%v|%v",
-		record.Kind, record.Name, record.Type, record.Ttl)
-}
-
-// RemoveDuplicatesFromChange compresses a CloudDNS change by removing
-// deletions and additions that would cancel each other out
-func RemoveDuplicatesFromChange(change *clouddns.Change) {
-	// build a map of the deletions for faster access
-	delIdxs := make(map[string]int)
-	for idx, record := range change.Deletions {
-		id := recordID(record)
-		delIdxs[id] = idx
-	}
-
-	// iterate through additions and point all duplicate entries to nil
-	// duplicate means, there is an addition that is identical to a deletion
-	for idx, record := range change.Additions {
-		id := recordID(record)
-		delIdx, ok := delIdxs[id]
-		if !ok ||
-			change.Deletions[delIdx] == nil ||
-			change.Additions[idx] == nil ||
-			!reflect.DeepEqual(change.Deletions[delIdx].Rrdatas,
-				change.Additions[idx].Rrdatas) {
-			continue
+// WithoutIgnored returns the resource record sets of a list that match none of
+// the ignore rules of a managed zone, the counterpart of IgnoredRRSets
+func WithoutIgnored(rrsets []*clouddns.ResourceRecordSet, ignore []config.IgnoreRule) []*clouddns.ResourceRecordSet {
+	out := []*clouddns.ResourceRecordSet{}
+	for _, rr := range rrsets {
+		if !ignored(rr, ignore) {
+			out = append(out, rr)
 		}
-		change.Additions[idx] = nil
-		change.Deletions[delIdx] = nil
 	}
-	change.Additions = removeNilPointersFromRRS(change.Additions)
-	change.Deletions = removeNilPointersFromRRS(change.Deletions)
+	return out
 }
 
-// FormatRRSets formats a resource record set in a human readable way and
-// returns a slice of strings that can be used for printing to screen or log
-func FormatRRSets(rrsets []*clouddns.ResourceRecordSet) []string {
-	var out []string
-	for _, rrset := range rrsets {
-		out = append(out, fmt.Sprintf("*%v %v %v",
-			rrset.Name, rrset.Type, rrset.Ttl))
-		for _, rdata := range rrset.Rrdatas {
-			out = append(out, fmt.Sprintf(" *%v", rdata))
+func ignored(rr *clouddns.ResourceRecordSet, ignore []config.IgnoreRule) bool {
+	for _, rule := range ignore {
+		if rule.Matches(rr.Name, rr.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectRRSets returns the resource record sets of a list that are part of
+// the subtrees of any of the FQDNs. Without FQDNs, all resource record sets are
+// returned.
+func SelectRRSets(rrsets []*clouddns.ResourceRecordSet, fqdns []string) []*clouddns.ResourceRecordSet {
+	if len(fqdns) == 0 {
+		return rrsets
+	}
+	out := []*clouddns.ResourceRecordSet{}
+	for _, rr := range rrsets {
+		for _, fqdn := range fqdns {
+			if lib.InSubtree(rr.Name, fqdn) {
+				out = append(out, rr)
+				break
+			}
 		}
 	}
 	return out
 }
 
-// FilterRRSets removes resource record sets that must not be managed by
-// dns-tools from a list. This is a safeguard.
-func FilterRRSets(rrsets []*clouddns.ResourceRecordSet, fqdn string) []*clouddns.ResourceRecordSet {
-	filtered := []*clouddns.ResourceRecordSet{}
+// ApexRecords builds the wanted SOA and NS records of a zone's apex from the
+// records currently on Cloud DNS and the managed zone's configuration. The
+// SOA's primary nameserver and serial as well as the NS records' data are
+// always taken from Cloud DNS. The apex is represented in an RRDB, so the
+// records are validated like zone data. Only managed records are returned in
+// canonical order, together with their types which can be passed on to
+// FilterRRSets.
+func ApexRecords(rrsets []*clouddns.ResourceRecordSet, mz config.ManagedZoneConfig) ([]*rrdb.Record, []string, error) {
+	apex := rrdb.New()
+	rtypes := []string{}
+	current := make(map[string]*clouddns.ResourceRecordSet)
 	for _, rr := range rrsets {
-		// ignore everything that is not a ResourceRecordSet
-		if rr.Kind != "dns#resourceRecordSet" {
+		if rr.Name == mz.FQDN && (rr.Type == "SOA" || rr.Type == "NS") {
+			current[rr.Type] = rr
+		}
+	}
+
+	if mz.SOA.IsManaged() {
+		rr, ok := current["SOA"]
+		if !ok || len(rr.Rrdatas) != 1 {
+			return nil, nil, fmt.Errorf("SOA record not found")
+		}
+		soa, err := rrdb.ParseSOA(rr.Rrdatas[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		if mz.SOA.Email != "" {
+			soa.RName, err = lib.EmailToRName(mz.SOA.Email)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if mz.SOA.Refresh != 0 {
+			soa.Refresh = mz.SOA.Refresh
+		}
+		if mz.SOA.Retry != 0 {
+			soa.Retry = mz.SOA.Retry
+		}
+		if mz.SOA.Expire != 0 {
+			soa.Expire = mz.SOA.Expire
+		}
+		if mz.SOA.NegTTL != 0 {
+			soa.Minimum = mz.SOA.NegTTL
+		}
+		ttl := int(rr.Ttl)
+		if mz.SOA.TTL != 0 {
+			ttl = mz.SOA.TTL
+		}
+		err = apex.SetSOA(mz.FQDN, ttl, soa.String())
+		if err != nil {
+			return nil, nil, fmt.Errorf("SOA record: %v", err)
+		}
+		rtypes = append(rtypes, "SOA")
+	}
+
+	if mz.NSTTL != 0 {
+		rr, ok := current["NS"]
+		if !ok {
+			return nil, nil, fmt.Errorf("NS record not found")
+		}
+		err := apex.SetNS(mz.FQDN, mz.NSTTL, rr.Rrdatas)
+		if err != nil {
+			return nil, nil, fmt.Errorf("NS record: %v", err)
+		}
+		rtypes = append(rtypes, "NS")
+	}
+	if len(rtypes) == 0 {
+		return []*rrdb.Record{}, rtypes, nil
+	}
+	records, err := apex.Records(mz.FQDN, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, rtypes, nil
+}
+
+// FillDelegations fills in the data of delegations to other managed zones,
+// which are NS records without data (see rrdb.SetManagedDelegation), with the
+// nameservers of the public Cloud DNS managed zones they refer to
+func FillDelegations(records []*rrdb.Record, mzs []*clouddns.ManagedZone) error {
+	nameservers := make(map[string][]string)
+	for _, mz := range mzs {
+		if mz.Visibility != "private" {
+			nameservers[mz.DnsName] = mz.NameServers
+		}
+	}
+	for _, record := range records {
+		if record.RType != "NS" || len(record.RDatas) != 0 {
+			continue
+		}
+		rdatas, ok := nameservers[record.FQDN]
+		if !ok || len(rdatas) == 0 {
+			return fmt.Errorf("delegation %v: managed zone not found",
+				record.FQDN)
+		}
+		record.RDatas = rdatas
+	}
+	return nil
+}
+
+// DNSSECConfig converts a DNSSEC configuration to a Cloud DNS managed zone
+// DNSSEC configuration. It returns nil if DNSSEC is not managed.
+func DNSSECConfig(dnssec config.DNSSECConfig) *clouddns.ManagedZoneDnsSecConfig {
+	if dnssec.State == "" {
+		return nil
+	}
+	out := &clouddns.ManagedZoneDnsSecConfig{
+		Kind:  "dns#managedZoneDnsSecConfig",
+		State: dnssec.State,
+	}
+	if dnssec.State == "off" {
+		return out
+	}
+	out.NonExistence = dnssec.NonExistence
+	kskLength, zskLength := int64(2048), int64(1024)
+	switch dnssec.Algorithm {
+	case "ecdsap256sha256":
+		kskLength, zskLength = 256, 256
+	case "ecdsap384sha384":
+		kskLength, zskLength = 384, 384
+	}
+	out.DefaultKeySpecs = []*clouddns.DnsKeySpec{
+		{
+			Kind:      "dns#dnsKeySpec",
+			KeyType:   "keySigning",
+			Algorithm: dnssec.Algorithm,
+			KeyLength: kskLength,
+		},
+		{
+			Kind:      "dns#dnsKeySpec",
+			KeyType:   "zoneSigning",
+			Algorithm: dnssec.Algorithm,
+			KeyLength: zskLength,
+		},
+	}
+	return out
+}
+
+// DNSSECDiffers compares a wanted DNSSEC configuration with the one of a
+// managed zone on Cloud DNS and returns true if they differ. Unmanaged DNSSEC
+// configurations never differ.
+func DNSSECDiffers(want config.DNSSECConfig, have *clouddns.ManagedZoneDnsSecConfig) bool {
+	if want.State == "" {
+		return false
+	}
+	if have == nil || have.State == "" {
+		return want.State != "off"
+	}
+	if want.State != have.State {
+		return true
+	}
+	if want.State == "off" {
+		return false
+	}
+	if have.NonExistence != "" && want.NonExistence != have.NonExistence {
+		return true
+	}
+	for _, spec := range have.DefaultKeySpecs {
+		if spec.Algorithm != want.Algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// DSRecords builds the DS records in presentation format for all active key
+// signing keys of a managed zone. These are the records the parent zone has
+// to publish.
+func DSRecords(keys []*clouddns.DnsKey) []string {
+	out := []string{}
+	for _, key := range keys {
+		if key.Type != "keySigning" || !key.IsActive {
 			continue
 		}
-		// don't even look at NS records for the zone itself
-		if rr.Type == "NS" && rr.Name == fqdn {
+		algorithm, ok := dnssecAlgorithmNumbers[key.Algorithm]
+		if !ok {
 			continue
 		}
-		// check everything else
-		if rr.Type == "NS" || rr.Type == "MX" || rr.Type == "TXT" || rr.Type == "CNAME" ||
-			rr.Type == "A" || rr.Type == "AAAA" {
-			filtered = append(filtered, rr)
+		for _, digest := range key.Digests {
+			digestType, ok := dsDigestTypeNumbers[digest.Type]
+			if !ok {
+				continue
+			}
+			out = append(out, fmt.Sprintf("%d %d %d %v", key.KeyTag, algorithm,
+				digestType, strings.ToUpper(digest.Digest)))
 		}
 	}
-	return filtered
+	return out
+}
+
+// GetDSRecords fetches the DNSSEC keys of a managed zone from Cloud DNS and
+// returns the matching DS records. See DSRecords for details.
+func GetDSRecords(service *clouddns.Service, projectID, mzName string) ([]string, error) {
+	var keys []*clouddns.DnsKey
+	err := service.DnsKeys.List(projectID, mzName).
+		DigestType("sha1,sha256,sha384").
+		Pages(context.Background(), func(page *clouddns.DnsKeysListResponse) error {
+			keys = append(keys, page.DnsKeys...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("list DNS keys: %v", err)
+	}
+	return DSRecords(keys), nil
+}
+
+// ManagedZoneDiff compares a managed zone configuration with a managed zone on
+// Cloud DNS. It returns a human readable list of differences and a managed
+// zone that can be used to patch the Cloud DNS managed zone. The patch is nil
+// if there is nothing to patch. Both zones have the same ID, so they never
+// differ in visibility.
+func ManagedZoneDiff(projectID string, want config.ManagedZoneConfig, have *clouddns.ManagedZone) ([]string, *clouddns.ManagedZone) {
+	diffs := []string{}
+	patch := &clouddns.ManagedZone{}
+	needsPatch := false
+
+	if want.Description != "" && want.Description != have.Description {
+		diffs = append(diffs, fmt.Sprintf("description: want %q, have %q",
+			want.Description, have.Description))
+		patch.Description = want.Description
+		needsPatch = true
+	}
+	if want.Labels != nil && !reflect.DeepEqual(want.Labels, have.Labels) &&
+		!(len(want.Labels) == 0 && len(have.Labels) == 0) {
+		diffs = append(diffs, fmt.Sprintf("labels: want %v, have %v",
+			want.Labels, have.Labels))
+		patch.Labels = want.Labels
+		if len(want.Labels) == 0 {
+			patch.NullFields = append(patch.NullFields, "Labels")
+		}
+		needsPatch = true
+	}
+	if want.Visibility == "private" {
+		wantNetworks := PrivateVisibilityConfig(projectID, want.Networks)
+		if !reflect.DeepEqual(networkURLs(wantNetworks),
+			networkURLs(have.PrivateVisibilityConfig)) {
+			diffs = append(diffs, fmt.Sprintf("networks: want %v, have %v",
+				networkURLs(wantNetworks),
+				networkURLs(have.PrivateVisibilityConfig)))
+			patch.PrivateVisibilityConfig = wantNetworks
+			needsPatch = true
+		}
+		wantForwarding := ForwardingConfig(want.Forwarding)
+		if !reflect.DeepEqual(forwardingTargets(wantForwarding),
+			forwardingTargets(have.ForwardingConfig)) {
+			diffs = append(diffs, fmt.Sprintf("forwarding: want %v, have %v",
+				forwardingTargets(wantForwarding),
+				forwardingTargets(have.ForwardingConfig)))
+			patch.ForwardingConfig = wantForwarding
+			if wantForwarding == nil {
+				patch.NullFields = append(patch.NullFields, "ForwardingConfig")
+			}
+			needsPatch = true
+		}
+		wantPeering := PeeringConfig(projectID, want.Peering)
+		if peeringTarget(wantPeering) != peeringTarget(have.PeeringConfig) {
+			diffs = append(diffs, fmt.Sprintf("peering: want %q, have %q",
+				peeringTarget(wantPeering), peeringTarget(have.PeeringConfig)))
+			patch.PeeringConfig = wantPeering
+			if wantPeering == nil {
+				patch.NullFields = append(patch.NullFields, "PeeringConfig")
+			}
+			needsPatch = true
+		}
+	}
+	if DNSSECDiffers(want.DNSSEC, have.DnssecConfig) {
+		haveState := "off"
+		if have.DnssecConfig != nil && have.DnssecConfig.State != "" {
+			haveState = have.DnssecConfig.State
+		}
+		diffs = append(diffs, fmt.Sprintf("DNSSEC: want %v (%v, %v), have %v",
+			want.DNSSEC.State, want.DNSSEC.Algorithm, want.DNSSEC.NonExistence,
+			haveState))
+		patch.DnssecConfig = DNSSECConfig(want.DNSSEC)
+		needsPatch = true
+	}
+
+	if !needsPatch {
+		return diffs, nil
+	}
+	return diffs, patch
+}
+
+// ManagedZoneID returns a string that identifies a Cloud DNS managed zone. It
+// matches the ID of the managed zone's configuration. See
+// config.ManagedZoneConfig.ID for details.
+func ManagedZoneID(mz *clouddns.ManagedZone) string {
+	if mz.Visibility == "private" {
+		return mz.DnsName + " (private)"
+	}
+	return mz.DnsName
+}
+
+// NetworkURL converts a VPC network name into the URL Cloud DNS expects.
+// Networks that are already URLs are returned untouched.
+func NetworkURL(projectID, network string) string {
+	if strings.HasPrefix(network, "https://") {
+		return network
+	}
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%v/global/networks/%v",
+		projectID, network)
+}
+
+// PrivateVisibilityConfig converts a list of VPC networks to a Cloud DNS
+// private visibility configuration
+func PrivateVisibilityConfig(projectID string, networks []string) *clouddns.ManagedZonePrivateVisibilityConfig {
+	out := &clouddns.ManagedZonePrivateVisibilityConfig{
+		Kind: "dns#managedZonePrivateVisibilityConfig",
+	}
+	for _, network := range networks {
+		out.Networks = append(out.Networks,
+			&clouddns.ManagedZonePrivateVisibilityConfigNetwork{
+				Kind:       "dns#managedZonePrivateVisibilityConfigNetwork",
+				NetworkUrl: NetworkURL(projectID, network),
+			})
+	}
+	return out
+}
+
+// ForwardingConfig converts a list of nameserver addresses to a Cloud DNS
+// forwarding configuration. It returns nil if there are no addresses.
+func ForwardingConfig(targets []string) *clouddns.ManagedZoneForwardingConfig {
+	if len(targets) == 0 {
+		return nil
+	}
+	out := &clouddns.ManagedZoneForwardingConfig{
+		Kind: "dns#managedZoneForwardingConfig",
+	}
+	for _, target := range targets {
+		nameserver := &clouddns.ManagedZoneForwardingConfigNameServerTarget{
+			Kind: "dns#managedZoneForwardingConfigNameServerTarget",
+		}
+		if strings.Contains(target, ":") {
+			nameserver.Ipv6Address = target
+		} else {
+			nameserver.Ipv4Address = target
+		}
+		out.TargetNameServers = append(out.TargetNameServers, nameserver)
+	}
+	return out
+}
+
+// PeeringConfig converts a VPC network to a Cloud DNS peering configuration.
+// It returns nil if there is no network.
+func PeeringConfig(projectID, network string) *clouddns.ManagedZonePeeringConfig {
+	if network == "" {
+		return nil
+	}
+	return &clouddns.ManagedZonePeeringConfig{
+		Kind: "dns#managedZonePeeringConfig",
+		TargetNetwork: &clouddns.ManagedZonePeeringConfigTargetNetwork{
+			Kind:       "dns#managedZonePeeringConfigTargetNetwork",
+			NetworkUrl: NetworkURL(projectID, network),
+		},
+	}
+}
+
+func networkURLs(in *clouddns.ManagedZonePrivateVisibilityConfig) []string {
+	out := []string{}
+	if in == nil {
+		return out
+	}
+	for _, network := range in.Networks {
+		out = append(out, network.NetworkUrl)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func forwardingTargets(in *clouddns.ManagedZoneForwardingConfig) []string {
+	out := []string{}
+	if in == nil {
+		return out
+	}
+	for _, target := range in.TargetNameServers {
+		if target.Ipv4Address != "" {
+			out = append(out, target.Ipv4Address)
+		}
+		if target.Ipv6Address != "" {
+			out = append(out, target.Ipv6Address)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func peeringTarget(in *clouddns.ManagedZonePeeringConfig) string {
+	if in == nil || in.TargetNetwork == nil {
+		return ""
+	}
+	return in.TargetNetwork.NetworkUrl
+}
+
+// IsEmptyZone checks if a list of resource record sets of a managed zone
+// only holds the records Cloud DNS creates for every managed zone, which are
+// the SOA and NS records of the zone itself.
+func IsEmptyZone(rrsets []*clouddns.ResourceRecordSet, fqdn string) bool {
+	for _, rr := range rrsets {
+		if rr.Name == fqdn && (rr.Type == "SOA" || rr.Type == "NS") {
+			continue
+		}
+		return false
+	}
+	return true
 }
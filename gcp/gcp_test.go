@@ -4,6 +4,7 @@ import (
 	"path"
 	"testing"
 
+	"github.com/egymgmbh/dns-tools/config"
 	"github.com/egymgmbh/dns-tools/rrdb"
 	"github.com/stretchr/testify/assert"
 
@@ -28,53 +29,6 @@ func TestGetDNSService(t *testing.T) {
 	}
 }
 
-func TestRemoveNilPointersFromRRS(t *testing.T) {
-	{
-		in := []*clouddns.ResourceRecordSet{
-			{
-				Kind:    "dns#resourceRecordSet",
-				Name:    "foo.test.",
-				Type:    "TXT",
-				Ttl:     300,
-				Rrdatas: []string{"John", "Galt", "Line"},
-			},
-			{
-				Kind:    "dns#resourceRecordSet",
-				Name:    "foo.test.",
-				Type:    "TXT",
-				Ttl:     300,
-				Rrdatas: []string{"John", "Galt", "Line"},
-			},
-		}
-		out := in
-		assert.Equal(t, out, removeNilPointersFromRRS(in))
-	}
-	{
-		in := []*clouddns.ResourceRecordSet{
-			{
-				Kind:    "dns#resourceRecordSet",
-				Name:    "foo.test.",
-				Type:    "TXT",
-				Ttl:     300,
-				Rrdatas: []string{"John", "Galt", "Line"},
-			},
-			nil,
-		}
-		out := []*clouddns.ResourceRecordSet{
-			in[0],
-		}
-		assert.Equal(t, out, removeNilPointersFromRRS(in))
-	}
-	{
-		in := []*clouddns.ResourceRecordSet{
-			nil,
-			nil,
-		}
-		out := []*clouddns.ResourceRecordSet{}
-		assert.Equal(t, out, removeNilPointersFromRRS(in))
-	}
-}
-
 func TestRRDBRecordsToCloudDNSRecords(t *testing.T) {
 	{
 		in := []*rrdb.Record{
@@ -98,121 +52,28 @@ func TestRRDBRecordsToCloudDNSRecords(t *testing.T) {
 	}
 }
 
-func TestRecordID(t *testing.T) {
-	{
-		in := clouddns.ResourceRecordSet{
-			Kind:    "dns#resourceRecordSet",
-			Name:    "foo.test.",
-			Type:    "TXT",
-			Ttl:     300,
-			Rrdatas: []string{},
-		}
-		id := recordID(&in)
-		assert.Equal(t, "dns#resourceRecordSet|foo.test.|TXT|300", id)
-	}
-}
-
-func TestRemoveDuplicatesFromChange(t *testing.T) {
-	// empty
-	{
-		in := clouddns.Change{
-			Deletions: []*clouddns.ResourceRecordSet{},
-			Additions: []*clouddns.ResourceRecordSet{},
-		}
-		out := in
-		RemoveDuplicatesFromChange(&in)
-		assert.Equal(t, out, in)
-	}
-	// must not change
-	{
-		in := clouddns.Change{
-			Deletions: []*clouddns.ResourceRecordSet{
-				{
-					Kind:    "dns#resourceRecordSet",
-					Name:    "foo.test.",
-					Type:    "AAAA",
-					Ttl:     300,
-					Rrdatas: []string{"2001:db8::1", "2001:db8:10::99"},
-				},
-			},
-			Additions: []*clouddns.ResourceRecordSet{},
-		}
-		out := in
-		RemoveDuplicatesFromChange(&in)
-		assert.Equal(t, out, in)
-	}
-	{
-		in := clouddns.Change{
-			Deletions: []*clouddns.ResourceRecordSet{},
-			Additions: []*clouddns.ResourceRecordSet{
-				{
-					Kind:    "dns#resourceRecordSet",
-					Name:    "foo.test.",
-					Type:    "AAAA",
-					Ttl:     300,
-					Rrdatas: []string{"2001:db8::1", "2001:db8:10::99"},
-				},
-			},
-		}
-		out := in
-		RemoveDuplicatesFromChange(&in)
-		assert.Equal(t, out, in)
-	}
-	// remove one deletion and one addition
-	{
-		in := clouddns.Change{
-			Deletions: []*clouddns.ResourceRecordSet{
-				{
-					Kind:    "dns#resourceRecordSet",
-					Name:    "foo.test.",
-					Type:    "AAAA",
-					Ttl:     300,
-					Rrdatas: []string{"2001:db8::1", "2001:db8:10::99"},
-				},
-				{
-					Kind:    "dns#resourceRecordSet",
-					Name:    "foo2.test.",
-					Type:    "AAAA",
-					Ttl:     300,
-					Rrdatas: []string{"2001:db8::1", "2001:db8:10::99"},
-				},
-			},
-			Additions: []*clouddns.ResourceRecordSet{
-				{
-					Kind:    "dns#resourceRecordSet",
-					Name:    "foo.test.",
-					Type:    "AAAA",
-					Ttl:     300,
-					Rrdatas: []string{"2001:db8::1", "2001:db8:10::99"},
-				},
-			},
-		}
-		out := clouddns.Change{
-			Deletions: []*clouddns.ResourceRecordSet{
-				in.Deletions[1],
-			},
-			Additions: []*clouddns.ResourceRecordSet{},
-		}
-		RemoveDuplicatesFromChange(&in)
-		assert.Equal(t, out, in)
+func TestSortRRSets(t *testing.T) {
+	rrsets := []*clouddns.ResourceRecordSet{
+		{Name: "www.example.com.", Type: "A", Rrdatas: []string{"192.0.2.10", "192.0.2.9"}},
+		{Name: "example.com.", Type: "SOA"},
+		{Name: "example.com.", Type: "MX", Rrdatas: []string{"10 mx.example.com.", "9 mx.example.com."}},
+		{Name: "a.www.example.com.", Type: "CNAME"},
+		{Name: "api.example.com.", Type: "AAAA"},
 	}
-}
-
-func TestFormatRRSets(t *testing.T) {
-	{
-		out := FormatRRSets([]*clouddns.ResourceRecordSet{
-			{
-				Kind:    "dns#resourceRecordSet",
-				Name:    "foo.test.",
-				Type:    "AAAA",
-				Ttl:     300,
-				Rrdatas: []string{"2001:db8::1", "2001:db8:10::99"},
-			},
-		})
-		assert.Equal(t,
-			[]string{"*foo.test. AAAA 300", " *2001:db8::1", " *2001:db8:10::99"},
-			out)
+	SortRRSets(rrsets)
+	keys := []string{}
+	for _, rrset := range rrsets {
+		keys = append(keys, rrset.Name+" "+rrset.Type)
 	}
+	assert.Equal(t, []string{
+		"example.com. SOA",
+		"example.com. MX",
+		"api.example.com. AAAA",
+		"www.example.com. A",
+		"a.www.example.com. CNAME",
+	}, keys)
+	assert.Equal(t, []string{"9 mx.example.com.", "10 mx.example.com."}, rrsets[1].Rrdatas)
+	assert.Equal(t, []string{"192.0.2.9", "192.0.2.10"}, rrsets[3].Rrdatas)
 }
 
 func TestFilterRRSets(t *testing.T) {
@@ -276,3 +137,409 @@ func TestFilterRRSets(t *testing.T) {
 		assert.Equal(t, rrsets, filtered)
 	}
 }
+
+func TestDNSSECConfig(t *testing.T) {
+	// unmanaged
+	{
+		assert.Equal(t, (*clouddns.ManagedZoneDnsSecConfig)(nil),
+			DNSSECConfig(config.DNSSECConfig{}))
+	}
+	// off
+	{
+		assert.Equal(t, &clouddns.ManagedZoneDnsSecConfig{
+			Kind:  "dns#managedZoneDnsSecConfig",
+			State: "off",
+		}, DNSSECConfig(config.DNSSECConfig{State: "off", Algorithm: "rsasha256"}))
+	}
+	// on
+	{
+		out := DNSSECConfig(config.DNSSECConfig{
+			State:        "on",
+			Algorithm:    "ecdsap256sha256",
+			NonExistence: "nsec",
+		})
+		assert.Equal(t, "on", out.State)
+		assert.Equal(t, "nsec", out.NonExistence)
+		assert.Equal(t, 2, len(out.DefaultKeySpecs))
+		for _, spec := range out.DefaultKeySpecs {
+			assert.Equal(t, "ecdsap256sha256", spec.Algorithm)
+			assert.Equal(t, int64(256), spec.KeyLength)
+		}
+	}
+}
+
+func TestDNSSECDiffers(t *testing.T) {
+	want := config.DNSSECConfig{
+		State:        "on",
+		Algorithm:    "rsasha256",
+		NonExistence: "nsec3",
+	}
+	have := DNSSECConfig(want)
+	assert.Equal(t, false, DNSSECDiffers(want, have))
+	assert.Equal(t, false, DNSSECDiffers(config.DNSSECConfig{}, have))
+	assert.Equal(t, false, DNSSECDiffers(config.DNSSECConfig{State: "off"}, nil))
+	assert.Equal(t, true, DNSSECDiffers(want, nil))
+	assert.Equal(t, true, DNSSECDiffers(config.DNSSECConfig{State: "off"}, have))
+	{
+		other := want
+		other.NonExistence = "nsec"
+		assert.Equal(t, true, DNSSECDiffers(other, have))
+	}
+	{
+		other := want
+		other.Algorithm = "ecdsap256sha256"
+		assert.Equal(t, true, DNSSECDiffers(other, have))
+	}
+}
+
+func TestDSRecords(t *testing.T) {
+	keys := []*clouddns.DnsKey{
+		{
+			Type:      "keySigning",
+			IsActive:  true,
+			Algorithm: "rsasha256",
+			KeyTag:    12345,
+			Digests: []*clouddns.DnsKeyDigest{
+				{Type: "sha256", Digest: "c0ffee"},
+				{Type: "sha1", Digest: "BEEF"},
+			},
+		},
+		{
+			Type:      "zoneSigning",
+			IsActive:  true,
+			Algorithm: "rsasha256",
+			KeyTag:    23456,
+			Digests: []*clouddns.DnsKeyDigest{
+				{Type: "sha256", Digest: "cafe"},
+			},
+		},
+		{
+			Type:      "keySigning",
+			IsActive:  false,
+			Algorithm: "rsasha256",
+			KeyTag:    34567,
+			Digests: []*clouddns.DnsKeyDigest{
+				{Type: "sha256", Digest: "dead"},
+			},
+		},
+	}
+	assert.Equal(t, []string{"12345 8 2 C0FFEE", "12345 8 1 BEEF"}, DSRecords(keys))
+	assert.Equal(t, []string{}, DSRecords(nil))
+}
+
+func TestManagedZoneDiff(t *testing.T) {
+	have := &clouddns.ManagedZone{
+		DnsName:     "foo.test.",
+		Description: "created by mzcreate",
+		Labels:      map[string]string{"team": "sre"},
+	}
+	// nothing managed, nothing to do
+	{
+		diffs, patch := ManagedZoneDiff("test", config.ManagedZoneConfig{
+			FQDN: "foo.test.",
+		}, have)
+		assert.Equal(t, []string{}, diffs)
+		assert.Equal(t, (*clouddns.ManagedZone)(nil), patch)
+	}
+	// in sync
+	{
+		diffs, patch := ManagedZoneDiff("test", config.ManagedZoneConfig{
+			FQDN:        "foo.test.",
+			Description: "created by mzcreate",
+			Labels:      map[string]string{"team": "sre"},
+		}, have)
+		assert.Equal(t, []string{}, diffs)
+		assert.Equal(t, (*clouddns.ManagedZone)(nil), patch)
+	}
+	// drift
+	{
+		diffs, patch := ManagedZoneDiff("test", config.ManagedZoneConfig{
+			FQDN:        "foo.test.",
+			Description: "foo zone",
+			Labels:      map[string]string{},
+			DNSSEC: config.DNSSECConfig{
+				State:        "on",
+				Algorithm:    "rsasha256",
+				NonExistence: "nsec3",
+			},
+		}, have)
+		assert.Equal(t, 3, len(diffs))
+		if assert.NotEqual(t, (*clouddns.ManagedZone)(nil), patch) {
+			assert.Equal(t, "foo zone", patch.Description)
+			assert.Equal(t, []string{"Labels"}, patch.NullFields)
+			assert.Equal(t, "on", patch.DnssecConfig.State)
+		}
+	}
+}
+
+func TestIsEmptyZone(t *testing.T) {
+	rrsets := []*clouddns.ResourceRecordSet{
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "foo.test.",
+			Type: "SOA",
+		},
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "foo.test.",
+			Type: "NS",
+		},
+	}
+	assert.Equal(t, true, IsEmptyZone(nil, "foo.test."))
+	assert.Equal(t, true, IsEmptyZone(rrsets, "foo.test."))
+	rrsets = append(rrsets, &clouddns.ResourceRecordSet{
+		Kind: "dns#resourceRecordSet",
+		Name: "bar.foo.test.",
+		Type: "NS",
+	})
+	assert.Equal(t, false, IsEmptyZone(rrsets, "foo.test."))
+}
+
+func TestManagedZoneDiffPrivate(t *testing.T) {
+	want := config.ManagedZoneConfig{
+		FQDN:       "foo.test.",
+		Visibility: "private",
+		Networks:   []string{"default"},
+		Forwarding: []string{"10.0.0.53"},
+	}
+	// in sync
+	{
+		diffs, patch := ManagedZoneDiff("test", want, &clouddns.ManagedZone{
+			DnsName:                 "foo.test.",
+			Visibility:              "private",
+			PrivateVisibilityConfig: PrivateVisibilityConfig("test", want.Networks),
+			ForwardingConfig:        ForwardingConfig(want.Forwarding),
+		})
+		assert.Equal(t, []string{}, diffs)
+		assert.Equal(t, (*clouddns.ManagedZone)(nil), patch)
+	}
+	// drift
+	{
+		diffs, patch := ManagedZoneDiff("test", want, &clouddns.ManagedZone{
+			DnsName:                 "foo.test.",
+			Visibility:              "private",
+			PrivateVisibilityConfig: PrivateVisibilityConfig("test", []string{"other"}),
+		})
+		assert.Equal(t, []string{
+			"networks: want [https://www.googleapis.com/compute/v1/projects/test/global/networks/default], " +
+				"have [https://www.googleapis.com/compute/v1/projects/test/global/networks/other]",
+			"forwarding: want [10.0.0.53], have []",
+		}, diffs)
+		if assert.NotEqual(t, (*clouddns.ManagedZone)(nil), patch) {
+			assert.Equal(t, want.Forwarding[0],
+				patch.ForwardingConfig.TargetNameServers[0].Ipv4Address)
+		}
+	}
+}
+
+func TestManagedZoneID(t *testing.T) {
+	assert.Equal(t, "foo.test.", ManagedZoneID(&clouddns.ManagedZone{
+		DnsName: "foo.test.",
+	}))
+	assert.Equal(t, "foo.test.", ManagedZoneID(&clouddns.ManagedZone{
+		DnsName:    "foo.test.",
+		Visibility: "public",
+	}))
+	assert.Equal(t, "foo.test. (private)", ManagedZoneID(&clouddns.ManagedZone{
+		DnsName:    "foo.test.",
+		Visibility: "private",
+	}))
+}
+
+func TestNetworkURL(t *testing.T) {
+	assert.Equal(t,
+		"https://www.googleapis.com/compute/v1/projects/test/global/networks/default",
+		NetworkURL("test", "default"))
+	assert.Equal(t,
+		"https://www.googleapis.com/compute/v1/projects/other/global/networks/vpc",
+		NetworkURL("test",
+			"https://www.googleapis.com/compute/v1/projects/other/global/networks/vpc"))
+}
+
+func TestForwardingConfig(t *testing.T) {
+	assert.Equal(t, (*clouddns.ManagedZoneForwardingConfig)(nil),
+		ForwardingConfig(nil))
+	out := ForwardingConfig([]string{"10.0.0.53", "2001:db8::53"})
+	if assert.Equal(t, 2, len(out.TargetNameServers)) {
+		assert.Equal(t, "10.0.0.53", out.TargetNameServers[0].Ipv4Address)
+		assert.Equal(t, "2001:db8::53", out.TargetNameServers[1].Ipv6Address)
+	}
+}
+
+func TestFilterRRSetsApex(t *testing.T) {
+	rrsets := []*clouddns.ResourceRecordSet{
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "foo.test.",
+			Type: "SOA",
+		},
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "foo.test.",
+			Type: "NS",
+		},
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "bar.foo.test.",
+			Type: "SOA", // not a valid record, must never be touched
+		},
+	}
+	assert.Equal(t, []*clouddns.ResourceRecordSet{},
+		FilterRRSets(rrsets, "foo.test."))
+	assert.Equal(t, rrsets[0:1], FilterRRSets(rrsets, "foo.test.", "SOA"))
+	assert.Equal(t, rrsets[1:2], FilterRRSets(rrsets, "foo.test.", "NS"))
+	assert.Equal(t, rrsets[0:2], FilterRRSets(rrsets, "foo.test.", "SOA", "NS"))
+}
+
+func TestWithoutIgnored(t *testing.T) {
+	rrsets := []*clouddns.ResourceRecordSet{
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "_acme-challenge.www.foo.test.",
+			Type: "TXT",
+		},
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "www.foo.test.",
+			Type: "TXT",
+		},
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "_acme-challenge.foo.test.",
+			Type: "CNAME",
+		},
+	}
+	ignore := []config.IgnoreRule{{Prefix: "_acme-challenge", Types: []string{"TXT"}}}
+	assert.Equal(t, rrsets[1:], WithoutIgnored(rrsets, ignore))
+	assert.Equal(t, rrsets, WithoutIgnored(rrsets, nil))
+	assert.Equal(t, rrsets[0:1], IgnoredRRSets(rrsets, ignore))
+	assert.Equal(t, []*clouddns.ResourceRecordSet{}, IgnoredRRSets(rrsets, nil))
+}
+
+func TestSelectRRSets(t *testing.T) {
+	rrsets := []*clouddns.ResourceRecordSet{
+		{Name: "foo.test.", Type: "A"},
+		{Name: "www.foo.test.", Type: "A"},
+		{Name: "a.www.foo.test.", Type: "A"},
+		{Name: "api.foo.test.", Type: "A"},
+	}
+	assert.Equal(t, rrsets, SelectRRSets(rrsets, nil))
+	assert.Equal(t, rrsets[1:3], SelectRRSets(rrsets, []string{"www.foo.test."}))
+	assert.Equal(t, rrsets[1:], SelectRRSets(rrsets,
+		[]string{"www.foo.test.", "api.foo.test."}))
+	assert.Equal(t, []*clouddns.ResourceRecordSet{},
+		SelectRRSets(rrsets, []string{"bar.test."}))
+}
+
+func TestApexRecords(t *testing.T) {
+	rrsets := []*clouddns.ResourceRecordSet{
+		{
+			Kind: "dns#resourceRecordSet",
+			Name: "foo.test.",
+			Type: "SOA",
+			Ttl:  21600,
+			Rrdatas: []string{"ns-cloud-a1.googledomains.com. " +
+				"cloud-dns-hostmaster.google.com. 1 21600 3600 259200 300"},
+		},
+		{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "foo.test.",
+			Type:    "NS",
+			Ttl:     21600,
+			Rrdatas: []string{"ns-cloud-a1.googledomains.com."},
+		},
+	}
+	// nothing managed
+	{
+		records, rtypes, err := ApexRecords(rrsets, config.ManagedZoneConfig{
+			FQDN: "foo.test.",
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []*rrdb.Record{}, records)
+		assert.Equal(t, []string{}, rtypes)
+	}
+	// SOA and NS
+	{
+		records, rtypes, err := ApexRecords(rrsets, config.ManagedZoneConfig{
+			FQDN: "foo.test.",
+			SOA: config.SOAConfig{
+				TTL:    3600,
+				NegTTL: 60,
+				Email:  "host.master@foo.test",
+			},
+			NSTTL: 86400,
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []string{"SOA", "NS"}, rtypes)
+		assert.Equal(t, []*rrdb.Record{
+			{
+				FQDN:   "foo.test.",
+				RType:  "NS",
+				TTL:    86400,
+				RDatas: []string{"ns-cloud-a1.googledomains.com."},
+			},
+			{
+				FQDN:  "foo.test.",
+				RType: "SOA",
+				TTL:   3600,
+				RDatas: []string{"ns-cloud-a1.googledomains.com. " +
+					"host\\.master.foo.test. 1 21600 3600 259200 60"},
+			},
+		}, records)
+	}
+	// missing SOA
+	{
+		_, _, err := ApexRecords(rrsets[1:], config.ManagedZoneConfig{
+			FQDN: "foo.test.",
+			SOA:  config.SOAConfig{Retry: 600},
+		})
+		assert.NotEqual(t, nil, err)
+	}
+	// invalid NS data
+	{
+		_, _, err := ApexRecords([]*clouddns.ResourceRecordSet{{
+			Kind:    "dns#resourceRecordSet",
+			Name:    "foo.test.",
+			Type:    "NS",
+			Ttl:     21600,
+			Rrdatas: []string{"ns-cloud-a1"},
+		}}, config.ManagedZoneConfig{
+			FQDN:  "foo.test.",
+			NSTTL: 86400,
+		})
+		assert.NotEqual(t, nil, err)
+	}
+}
+
+func TestFillDelegations(t *testing.T) {
+	mzs := []*clouddns.ManagedZone{
+		{
+			DnsName:     "sub.foo.test.",
+			NameServers: []string{"ns-cloud-b1.googledomains.com."},
+		},
+		{
+			DnsName:     "private.foo.test.",
+			Visibility:  "private",
+			NameServers: []string{"ns-gcp-private.googledomains.com."},
+		},
+	}
+	records := []*rrdb.Record{
+		{FQDN: "sub.foo.test.", RType: "NS", TTL: 300},
+		{FQDN: "ext.foo.test.", RType: "NS", TTL: 300,
+			RDatas: []string{"ns1.example.com."}},
+	}
+	err := FillDelegations(records, mzs)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []*rrdb.Record{
+		{FQDN: "sub.foo.test.", RType: "NS", TTL: 300,
+			RDatas: []string{"ns-cloud-b1.googledomains.com."}},
+		{FQDN: "ext.foo.test.", RType: "NS", TTL: 300,
+			RDatas: []string{"ns1.example.com."}},
+	}, records)
+
+	// private zones can not be delegated to
+	err = FillDelegations([]*rrdb.Record{
+		{FQDN: "private.foo.test.", RType: "NS", TTL: 300},
+	}, mzs)
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,116 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	clouddns "google.golang.org/api/dns/v1"
+)
+
+// SnapshotVersion is the version of the snapshot file format. It changes
+// whenever older tools can no longer read snapshots correctly.
+const SnapshotVersion = 1
+
+// snapshotTimeFormat names snapshot files, so they sort chronologically
+const snapshotTimeFormat = "20060102T150405Z"
+
+// Snapshot holds the settings and resource record sets of a managed zone as
+// they were live on Cloud DNS at a point in time
+type Snapshot struct {
+	Version     int                           `json:"version"`
+	Taken       time.Time                     `json:"taken"`
+	ProjectID   string                        `json:"projectId"`
+	ManagedZone *clouddns.ManagedZone         `json:"managedZone"`
+	RRSets      []*clouddns.ResourceRecordSet `json:"rrsets"`
+}
+
+// TakeSnapshot fetches all resource record sets of a managed zone from Cloud
+// DNS
+func TakeSnapshot(ctx context.Context, service *clouddns.Service, projectID string, mz *clouddns.ManagedZone) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Version:     SnapshotVersion,
+		Taken:       time.Now().UTC(),
+		ProjectID:   projectID,
+		ManagedZone: mz,
+		RRSets:      []*clouddns.ResourceRecordSet{},
+	}
+	err := service.ResourceRecordSets.List(projectID, mz.Name).
+		Pages(ctx, func(page *clouddns.ResourceRecordSetsListResponse) error {
+			snapshot.RRSets = append(snapshot.RRSets, page.Rrsets...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("list resource record sets: %v", err)
+	}
+	SortRRSets(snapshot.RRSets)
+	return snapshot, nil
+}
+
+// ZoneFile renders the resource record sets of a snapshot in zone file
+// format, one record per line. It is meant for humans, restores read the
+// JSON file.
+func (snapshot *Snapshot) ZoneFile() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "; managed zone %v, project %v, taken %v\n",
+		snapshot.ManagedZone.Name, snapshot.ProjectID,
+		snapshot.Taken.Format(time.RFC3339))
+	for _, rrset := range snapshot.RRSets {
+		for _, rdata := range rrset.Rrdatas {
+			fmt.Fprintf(&b, "%v %v IN %v %v\n", rrset.Name, rrset.Ttl,
+				rrset.Type, rdata)
+		}
+	}
+	return b.Bytes()
+}
+
+// Save writes a snapshot to a JSON file and a zone file, see ZoneFile, named
+// after the time the snapshot was taken, in a subdirectory of a directory
+// named after the managed zone. It returns the name of the JSON file.
+func (snapshot *Snapshot) Save(directory string) (string, error) {
+	directory = filepath.Join(directory, snapshot.ManagedZone.Name)
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Join(directory, snapshot.Taken.UTC().Format(snapshotTimeFormat))
+	err = ioutil.WriteFile(base+".json", append(data, '\n'), 0644)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(base+".zone", snapshot.ZoneFile(), 0644)
+	if err != nil {
+		return "", err
+	}
+	return base + ".json", nil
+}
+
+// LoadSnapshot reads a snapshot from a JSON file written by Save
+func LoadSnapshot(fname string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	err = json.Unmarshal(data, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %v: %v", fname, err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		return nil, fmt.Errorf("snapshot %v: unsupported version %v",
+			fname, snapshot.Version)
+	}
+	if snapshot.ManagedZone == nil {
+		return nil, fmt.Errorf("snapshot %v: managed zone missing", fname)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,59 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clouddns "google.golang.org/api/dns/v1"
+)
+
+func TestSnapshot(t *testing.T) {
+	directory, err := ioutil.TempDir("", "snapshot")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(directory)
+
+	snapshot := &Snapshot{
+		Version:   SnapshotVersion,
+		Taken:     time.Date(2018, 3, 1, 12, 30, 0, 0, time.UTC),
+		ProjectID: "project",
+		ManagedZone: &clouddns.ManagedZone{
+			Name:    "com--example",
+			DnsName: "example.com.",
+		},
+		RRSets: []*clouddns.ResourceRecordSet{
+			testRRSet("example.com.", "MX", 300, "10 mx1.example.com.",
+				"20 mx2.example.com."),
+			testRRSet("www.example.com.", "A", 60, "192.0.2.1"),
+		},
+	}
+	assert.Equal(t, "; managed zone com--example, project project, taken 2018-03-01T12:30:00Z\n"+
+		"example.com. 300 IN MX 10 mx1.example.com.\n"+
+		"example.com. 300 IN MX 20 mx2.example.com.\n"+
+		"www.example.com. 60 IN A 192.0.2.1\n", string(snapshot.ZoneFile()))
+
+	fname, err := snapshot.Save(directory)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, filepath.Join(directory, "com--example", "20180301T123000Z.json"), fname)
+	_, err = os.Stat(strings.TrimSuffix(fname, ".json") + ".zone")
+	assert.Equal(t, nil, err)
+
+	loaded, err := LoadSnapshot(fname)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, snapshot, loaded)
+
+	// other versions of the file format
+	snapshot.Version = SnapshotVersion + 1
+	snapshot.Taken = snapshot.Taken.Add(time.Hour)
+	fname, err = snapshot.Save(directory)
+	assert.Equal(t, nil, err)
+	_, err = LoadSnapshot(fname)
+	assert.NotEqual(t, nil, err)
+
+	_, err = LoadSnapshot(filepath.Join(directory, "missing.json"))
+	assert.NotEqual(t, nil, err)
+}
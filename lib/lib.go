@@ -6,10 +6,15 @@ import (
 	"net"
 	"regexp"
 	"strings"
+
+	"github.com/miekg/dns"
 )
 
+const resolvConf = "/etc/resolv.conf"
+
 var (
 	regexHostname = regexp.MustCompile(`^(([a-zA-Z0-9_]|[a-zA-Z0-9_][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])\.$`)
+	regexView     = regexp.MustCompile(`^[a-z0-9]([a-z0-9\-]*[a-z0-9])?$`)
 )
 
 // Lookup looks up a resource record and returns the associated
@@ -58,12 +63,47 @@ func Lookup(fqdn, rtype string) ([]string, error) {
 				rdatas = append(rdatas, address)
 			}
 		}
+	case "DS":
+		rdatas, err = lookupDS(fqdn)
 	default:
 		return rdatas, fmt.Errorf("unsupported record type: %v", rtype)
 	}
 	return rdatas, err
 }
 
+// lookupDS asks the system's resolver for the DS records of a FQDN. The Go
+// resolver does not know about DNSSEC record types, so we ask directly.
+func lookupDS(fqdn string) ([]string, error) {
+	var rdatas []string
+	conf, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil {
+		return rdatas, err
+	}
+	if len(conf.Servers) == 0 {
+		return rdatas, fmt.Errorf("no nameservers in %v", resolvConf)
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(fqdn, dns.TypeDS)
+	msg.SetEdns0(4096, true)
+	client := new(dns.Client)
+	server := net.JoinHostPort(conf.Servers[0], conf.Port)
+	response, _, err := client.Exchange(msg, server)
+	if err != nil {
+		return rdatas, err
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return rdatas, fmt.Errorf("lookup %v: %v", fqdn,
+			dns.RcodeToString[response.Rcode])
+	}
+	for _, rr := range response.Answer {
+		if ds, ok := rr.(*dns.DS); ok {
+			rdatas = append(rdatas, fmt.Sprintf("%d %d %d %v", ds.KeyTag,
+				ds.Algorithm, ds.DigestType, strings.ToUpper(ds.Digest)))
+		}
+	}
+	return rdatas, nil
+}
+
 // RDatasEqual compares two unsorted slices of resource record data and
 // returns true if their contents are the same
 func RDatasEqual(a, b []string) bool {
@@ -99,6 +139,27 @@ func MakeFQDN(name, zone string) string {
 	return name + "." + zone
 }
 
+// InSubtree checks if a FQDN is equal to or below another FQDN, ignoring case
+func InSubtree(fqdn, apex string) bool {
+	fqdn, apex = strings.ToLower(fqdn), strings.ToLower(apex)
+	return fqdn == apex || apex == "." || strings.HasSuffix(fqdn, "."+apex)
+}
+
+// EmailToRName converts an email address into the mailbox format of a SOA
+// record, e.g. host.master@example.com becomes host\.master.example.com.
+func EmailToRName(email string) (string, error) {
+	parts := strings.Split(email, "@")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", fmt.Errorf("invalid email address: %v", email)
+	}
+	domain := strings.TrimSuffix(parts[1], ".") + "."
+	err := IsValidFQDN(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address: %v", email)
+	}
+	return strings.Replace(parts[0], ".", "\\.", -1) + "." + domain, nil
+}
+
 // IsValidIPv4 validates a string that contains an IPv4 address
 func IsValidIPv4(address string) error {
 	ip := net.ParseIP(address)
@@ -132,6 +193,14 @@ func IsValidFQDN(fqdn string) error {
 	return nil
 }
 
+// IsValidView validates the name of a zonedata view
+func IsValidView(view string) error {
+	if !regexView.MatchString(view) {
+		return fmt.Errorf("invalid view: %v", view)
+	}
+	return nil
+}
+
 // IsValidTTL validates an integer for an acceptable DNS Time To Live
 // value
 func IsValidTTL(ttl int) error {
@@ -154,3 +223,44 @@ func TextToQuotedStrings(s string) string {
 	s = s[1 : len(s)-1]
 	return s
 }
+
+// CompareNames compares two FQDNs in canonical DNS name order as defined in
+// RFC 4034 section 6.1: Names are compared label by label starting with the
+// rightmost label, labels are compared case-insensitively as octet strings
+// and a name sorts before all names below it. It returns -1, 0 or 1.
+func CompareNames(a, b string) int {
+	aLabels := dns.SplitDomainName(strings.ToLower(a))
+	bLabels := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(aLabels)-1, len(bLabels)-1; i >= 0 || j >= 0; i, j = i-1, j-1 {
+		if i < 0 {
+			return -1
+		}
+		if j < 0 {
+			return 1
+		}
+		if c := strings.Compare(aLabels[i], bLabels[j]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// CompareRTypes compares two record types by their type numbers, e.g. A (1)
+// sorts before NS (2). Unknown types sort after all known types by name. It
+// returns -1, 0 or 1.
+func CompareRTypes(a, b string) int {
+	aCode, aOK := dns.StringToType[a]
+	bCode, bOK := dns.StringToType[b]
+	switch {
+	case aOK && bOK && aCode != bCode:
+		if aCode < bCode {
+			return -1
+		}
+		return 1
+	case aOK && !bOK:
+		return -1
+	case !aOK && bOK:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
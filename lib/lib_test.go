@@ -152,6 +152,14 @@ func TestMakeFQDN(t *testing.T) {
 	}
 }
 
+func TestInSubtree(t *testing.T) {
+	assert.Equal(t, true, InSubtree("example.com.", "example.com."))
+	assert.Equal(t, true, InSubtree("www.Example.com.", "example.COM."))
+	assert.Equal(t, true, InSubtree("example.com.", "."))
+	assert.Equal(t, false, InSubtree("myexample.com.", "example.com."))
+	assert.Equal(t, false, InSubtree("com.", "example.com."))
+}
+
 func TestIsValidIPv4(t *testing.T) {
 	invalidIPv4s := []string{
 		"",
@@ -243,6 +251,15 @@ func TestIsValidFQDN(t *testing.T) {
 	}
 }
 
+func TestIsValidView(t *testing.T) {
+	for _, view := range []string{"", "-", "Internal", "internal-", "in_ternal"} {
+		assert.NotEqual(t, nil, IsValidView(view))
+	}
+	for _, view := range []string{"internal", "a", "eu-west1"} {
+		assert.Equal(t, nil, IsValidView(view))
+	}
+}
+
 func TestTextToQuotedStrings(t *testing.T) {
 	quotedTexts := []struct {
 		in  string
@@ -277,3 +294,62 @@ func TestTextToQuotedStrings(t *testing.T) {
 		assert.Equal(t, s.out, TextToQuotedStrings(s.in))
 	}
 }
+
+func TestEmailToRName(t *testing.T) {
+	valid := []struct {
+		in  string
+		out string
+	}{
+		{in: "hostmaster@example.com", out: "hostmaster.example.com."},
+		{in: "hostmaster@example.com.", out: "hostmaster.example.com."},
+		{in: "host.master@example.com", out: "host\\.master.example.com."},
+	}
+	for _, test := range valid {
+		rname, err := EmailToRName(test.in)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, test.out, rname)
+	}
+	invalid := []string{"", "hostmaster", "@example.com", "a@b@example.com",
+		"hostmaster@-example.com"}
+	for _, email := range invalid {
+		_, err := EmailToRName(email)
+		assert.NotEqual(t, nil, err, email)
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	// example from RFC 4034 section 6.1
+	names := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"*.z.example.",
+		"\\200.z.example.",
+	}
+	for i := range names {
+		for j := range names {
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			assert.Equal(t, expected, CompareNames(names[i], names[j]),
+				"%v %v", names[i], names[j])
+		}
+	}
+	assert.Equal(t, 0, CompareNames("Example.com.", "example.COM."))
+	assert.Equal(t, -1, CompareNames("com.", "example.com."))
+	assert.Equal(t, 1, CompareNames("example.org.", "www.example.com."))
+}
+
+func TestCompareRTypes(t *testing.T) {
+	assert.Equal(t, -1, CompareRTypes("A", "NS"))
+	assert.Equal(t, 1, CompareRTypes("AAAA", "TXT"))
+	assert.Equal(t, 0, CompareRTypes("MX", "MX"))
+	assert.Equal(t, -1, CompareRTypes("CAA", "FOO"))
+	assert.Equal(t, -1, CompareRTypes("BAR", "FOO"))
+}
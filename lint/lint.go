@@ -0,0 +1,542 @@
+// Package lint checks the zonedata of managed zones for likely mistakes that do
+// not prevent it from being loaded, e.g. aliases pointing to names that do not
+// exist. Every rule can be disabled globally or ignored for single names with a
+// comment like "# dbcheck:ignore mx-spf" in the zonedata. Rules are either
+// errors or warnings about style.
+package lint
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/rrdb"
+)
+
+// MinTTL is the lowest TTL accepted by the ttl-low rule
+const MinTTL = 60
+
+var (
+	// private and otherwise not globally reachable address ranges
+	privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12",
+		"192.168.0.0/16", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"fc00::/7", "fe80::/10", "::1/128")
+)
+
+// Rule is a single lint rule. Findings of rules with error severity point to
+// records that do not work as intended, warnings point to questionable style.
+type Rule struct {
+	Name        string
+	Description string
+	Severity    rrdb.Severity
+	check       func(l *linter) []Finding
+}
+
+// Rules lists all lint rules. All of them are enabled by default.
+var Rules = []Rule{
+	{"dangling-target", "CNAME, MX and NS targets within managed zones must exist",
+		rrdb.SeverityError, checkDanglingTargets},
+	{"mx-cname", "MX targets must not be aliases", rrdb.SeverityError,
+		checkMXCNAME},
+	{"mx-spf", "names with mailservers need a SPF record", rrdb.SeverityWarning,
+		checkMXSPF},
+	{"mx-dmarc", "names with mailservers need a DMARC record",
+		rrdb.SeverityWarning, checkMXDMARC},
+	{"ttl-low", fmt.Sprintf("TTLs must be at least %v seconds", MinTTL),
+		rrdb.SeverityWarning, checkTTLLow},
+	{"ttl-inconsistent", "A and AAAA records of a name must have the same TTL",
+		rrdb.SeverityWarning, checkTTLInconsistent},
+	{"unmanaged", "names and zones must be part of a managed zone",
+		rrdb.SeverityWarning, checkUnmanaged},
+	{"zone-overlap", "nested managed zones must be delegated",
+		rrdb.SeverityError, checkZoneOverlap},
+	{"private-address", "public zones must not hold private addresses",
+		rrdb.SeverityWarning, checkPrivateAddresses},
+}
+
+// Finding is a single violation of a lint rule
+type Finding struct {
+	Rule     string
+	Severity rrdb.Severity // severity of the rule
+	Zone     string        // ID of the managed zone, empty for unmanaged names
+	FQDN     string
+	RType    string // empty if the finding is about a name as a whole
+	Position string // file:line:col of the name's definition, if known
+	Message  string
+	source   string // zonedata directory and view the finding stems from
+}
+
+// String formats a finding like the problems found while loading zonedata,
+// prefixed with its position
+func (finding Finding) String() string {
+	msg := finding.FQDN
+	if finding.RType != "" {
+		msg += " " + finding.RType
+	}
+	msg = fmt.Sprintf("%v: %v (%v)", msg, finding.Message, finding.Rule)
+	if finding.Zone != "" {
+		msg = fmt.Sprintf("managed zone %v: %v", finding.Zone, msg)
+	}
+	if finding.Position == "" {
+		return msg
+	}
+	return fmt.Sprintf("%v: %v", finding.Position, msg)
+}
+
+// zone holds a managed zone along with its records
+type zone struct {
+	config.ManagedZoneConfig
+	db      *rrdb.RRDB
+	records []*rrdb.Record
+}
+
+func (z zone) source() string {
+	return source(z.ZoneDataDirectory, z.View)
+}
+
+func source(directory, view string) string {
+	return fmt.Sprintf("%v (view %q)", directory, view)
+}
+
+// linter holds the data the rules work on
+type linter struct {
+	zones   []zone
+	origins map[string][]rrdb.Origin // by source
+}
+
+// Lint checks the zonedata of all managed zones with all rules except the
+// disabled ones. The zonedata has to load without errors. Findings ignored in
+// the zonedata are left out.
+func Lint(config *config.Config, dbs map[string]rrdb.Views,
+	disabled []string) ([]Finding, error) {
+	skip := make(map[string]bool)
+	for _, name := range disabled {
+		if _, err := FindRule(name); err != nil {
+			return nil, err
+		}
+		skip[name] = true
+	}
+
+	l := &linter{
+		origins: make(map[string][]rrdb.Origin),
+	}
+	for _, mz := range config.ManagedZones {
+		db, err := dbs[mz.ZoneDataDirectory].View(mz.View)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		records, err := db.Zone(mz.FQDN, mz.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		z := zone{ManagedZoneConfig: mz, db: db, records: records}
+		l.zones = append(l.zones, z)
+		if _, ok := l.origins[z.source()]; ok {
+			continue
+		}
+		origins, err := rrdb.OriginsFromDirectory(mz.ZoneDataDirectory, mz.View)
+		if err != nil {
+			return nil, fmt.Errorf("managed zone %v: %v", mz.ID(), err)
+		}
+		l.origins[z.source()] = origins
+	}
+
+	findings := []Finding{}
+	seen := make(map[string]bool)
+	for _, rule := range Rules {
+		if skip[rule.Name] {
+			continue
+		}
+		for _, finding := range rule.check(l) {
+			finding.Rule = rule.Name
+			finding.Severity = rule.Severity
+			if l.locate(&finding) {
+				continue // ignored
+			}
+			// names of overlapping managed zones are checked several times
+			if seen[finding.String()] {
+				continue
+			}
+			seen[finding.String()] = true
+			findings = append(findings, finding)
+		}
+	}
+	return findings, nil
+}
+
+// FindRule looks up a rule by name
+func FindRule(name string) (Rule, error) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, nil
+		}
+	}
+	return Rule{}, fmt.Errorf("unknown lint rule: %v", name)
+}
+
+// locate sets the position of a finding from the first definition of the
+// records it refers to. It returns true if any of the definitions ignores the
+// finding's rule.
+func (l *linter) locate(finding *Finding) bool {
+	ignored := false
+	for _, origin := range l.origins[finding.source] {
+		if origin.FQDN != finding.FQDN {
+			continue
+		}
+		for _, rtype := range origin.RTypes {
+			if finding.RType != "" && rtype != finding.RType {
+				continue
+			}
+			if finding.Position == "" {
+				finding.Position = origin.Position
+			}
+			for _, rule := range origin.Ignored[rtype] {
+				if rule == finding.Rule {
+					ignored = true
+				}
+			}
+		}
+	}
+	return ignored
+}
+
+// eachRecord calls a function for every record of every managed zone
+func (l *linter) eachRecord(fn func(z zone, record *rrdb.Record) []Finding) []Finding {
+	findings := []Finding{}
+	for _, z := range l.zones {
+		for _, record := range z.records {
+			findings = append(findings, fn(z, record)...)
+		}
+	}
+	return findings
+}
+
+// newFinding creates a finding about a record of a managed zone
+func newFinding(z zone, record *rrdb.Record, format string,
+	args ...interface{}) Finding {
+	return Finding{
+		Zone:    z.ID(),
+		FQDN:    record.FQDN,
+		RType:   record.RType,
+		Message: fmt.Sprintf(format, args...),
+		source:  z.source(),
+	}
+}
+
+// inZone checks if a FQDN is part of the zone with the apex origin
+func inZone(fqdn, origin string) bool {
+	return fqdn == origin || strings.HasSuffix(fqdn, "."+origin)
+}
+
+// owners returns the managed zones a FQDN belongs to, that is the zones with the
+// longest matching apex. There are several for zones that exist both, public
+// and private.
+func (l *linter) owners(fqdn string) []zone {
+	zones := []zone{}
+	for _, z := range l.zones {
+		if !inZone(fqdn, z.FQDN) {
+			continue
+		}
+		if len(zones) != 0 && len(z.FQDN) < len(zones[0].FQDN) {
+			continue
+		}
+		if len(zones) != 0 && len(z.FQDN) > len(zones[0].FQDN) {
+			zones = zones[:0]
+		}
+		zones = append(zones, z)
+	}
+	return zones
+}
+
+// delegated checks if a FQDN of a zone is part of a delegated subtree
+func (z zone) delegated(fqdn string) bool {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."+z.FQDN), ".")
+	if fqdn == z.FQDN {
+		labels = nil
+	}
+	name := z.FQDN
+	for idx := len(labels) - 1; idx >= 0; idx-- {
+		name = labels[idx] + "." + name
+		if _, err := z.db.NS(name, z.TTL); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// targets returns the FQDNs a record points to
+func targets(record *rrdb.Record) []string {
+	fqdns := []string{}
+	for _, rdata := range record.RDatas {
+		switch record.RType {
+		case "CNAME", "NS":
+			fqdns = append(fqdns, rdata)
+		case "MX":
+			fields := strings.Fields(rdata)
+			if len(fields) == 2 && fields[1] != "." {
+				fqdns = append(fqdns, fields[1])
+			}
+		}
+	}
+	return fqdns
+}
+
+func checkDanglingTargets(l *linter) []Finding {
+	return l.eachRecord(func(z zone, record *rrdb.Record) []Finding {
+		findings := []Finding{}
+		for _, target := range targets(record) {
+			owners := l.owners(target)
+			if len(owners) == 0 {
+				continue // not ours
+			}
+			exists := false
+			for _, owner := range owners {
+				if owner.delegated(target) {
+					exists = true // not ours either
+					break
+				}
+				records, err := owner.db.Records(target, owner.TTL)
+				if err == nil && len(records) != 0 {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				findings = append(findings, newFinding(z, record,
+					"target %v does not exist", target))
+			}
+		}
+		return findings
+	})
+}
+
+func checkMXCNAME(l *linter) []Finding {
+	return l.eachRecord(func(z zone, record *rrdb.Record) []Finding {
+		findings := []Finding{}
+		if record.RType != "MX" {
+			return findings
+		}
+		for _, target := range targets(record) {
+			for _, owner := range l.owners(target) {
+				if _, err := owner.db.CNAME(target, owner.TTL); err == nil {
+					findings = append(findings, newFinding(z, record,
+						"target %v is an alias", target))
+					break
+				}
+			}
+		}
+		return findings
+	})
+}
+
+// texts returns the unquoted TXT records of a FQDN
+func (z zone) texts(fqdn string) []string {
+	texts := []string{}
+	record, err := z.db.TXT(fqdn, z.TTL)
+	if err != nil {
+		return texts
+	}
+	for _, rdata := range record.RDatas {
+		text, err := strconv.Unquote(rdata)
+		if err != nil {
+			text = rdata
+		}
+		texts = append(texts, text)
+	}
+	return texts
+}
+
+// hasText checks if a FQDN has a TXT record starting with a version tag like
+// "v=spf1", regardless of case
+func (z zone) hasText(fqdn, version string) bool {
+	for _, text := range z.texts(fqdn) {
+		text = strings.ToLower(text)
+		if strings.HasPrefix(text, version+" ") ||
+			strings.HasPrefix(text, version+";") || text == version {
+			return true
+		}
+	}
+	return false
+}
+
+func checkMXSPF(l *linter) []Finding {
+	return l.eachRecord(func(z zone, record *rrdb.Record) []Finding {
+		if record.RType != "MX" || z.hasText(record.FQDN, "v=spf1") {
+			return nil
+		}
+		return []Finding{newFinding(z, record, "no SPF record")}
+	})
+}
+
+func checkMXDMARC(l *linter) []Finding {
+	return l.eachRecord(func(z zone, record *rrdb.Record) []Finding {
+		if record.RType != "MX" {
+			return nil
+		}
+		// a DMARC policy of a parent domain applies to its subdomains as well
+		for fqdn := record.FQDN; inZone(fqdn, z.FQDN); {
+			if z.hasText("_dmarc."+fqdn, "v=dmarc1") {
+				return nil
+			}
+			idx := strings.Index(fqdn, ".")
+			fqdn = fqdn[idx+1:]
+		}
+		return []Finding{newFinding(z, record, "no DMARC record")}
+	})
+}
+
+func checkTTLLow(l *linter) []Finding {
+	return l.eachRecord(func(z zone, record *rrdb.Record) []Finding {
+		if record.TTL >= MinTTL {
+			return nil
+		}
+		return []Finding{newFinding(z, record, "TTL %v is too low", record.TTL)}
+	})
+}
+
+func checkTTLInconsistent(l *linter) []Finding {
+	return l.eachRecord(func(z zone, record *rrdb.Record) []Finding {
+		if record.RType != "AAAA" {
+			return nil
+		}
+		a, err := z.db.A(record.FQDN, z.TTL)
+		if err != nil || a.TTL == record.TTL {
+			return nil
+		}
+		return []Finding{newFinding(z, record, "TTL %v differs from A TTL %v",
+			record.TTL, a.TTL)}
+	})
+}
+
+// sources returns the zonedata directories and views of all managed zones in
+// lexical order
+func (l *linter) sources() []string {
+	sources := []string{}
+	for source := range l.origins {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func checkUnmanaged(l *linter) []Finding {
+	findings := []Finding{}
+	for _, source := range l.sources() {
+		var db *rrdb.RRDB
+		apexes := []string{}
+		for _, z := range l.zones {
+			if z.source() == source {
+				db = z.db
+				apexes = append(apexes, z.FQDN)
+			}
+		}
+		unmanaged := make(map[string]bool)
+		for _, fqdn := range db.Unmanaged(apexes) {
+			unmanaged[fqdn] = true
+		}
+		// zones not covered at all are reported as a whole, e.g. for typos in
+		// their FQDN
+		reported := make(map[string]bool)
+		for _, origin := range l.origins[source] {
+			if !unmanaged[origin.FQDN] {
+				continue
+			}
+			managed := false
+			for _, apex := range apexes {
+				managed = managed || inZone(origin.Zone, apex)
+			}
+			if managed {
+				findings = append(findings, Finding{
+					FQDN:    origin.FQDN,
+					Message: fmt.Sprintf("not part of any managed zone using %v", source),
+					source:  source,
+				})
+				continue
+			}
+			if reported[origin.Zone] {
+				continue
+			}
+			reported[origin.Zone] = true
+			findings = append(findings, Finding{
+				FQDN:     origin.Zone,
+				Position: origin.ZonePosition,
+				Message:  fmt.Sprintf("zone is not managed using %v", source),
+				source:   source,
+			})
+		}
+	}
+	return findings
+}
+
+func checkZoneOverlap(l *linter) []Finding {
+	findings := []Finding{}
+	for _, child := range l.zones {
+		idx := strings.Index(child.FQDN, ".")
+		for _, parent := range l.owners(child.FQDN[idx+1:]) {
+			if parent.Visibility != child.Visibility {
+				continue
+			}
+			if _, err := parent.db.NS(child.FQDN, parent.TTL); err == nil {
+				continue // delegated
+			}
+			if _, err := parent.db.ManagedDelegation(child.FQDN,
+				parent.TTL); err == nil {
+				continue
+			}
+			finding := Finding{
+				Zone:   parent.ID(),
+				FQDN:   child.FQDN,
+				source: parent.source(),
+			}
+			records, err := parent.db.Zone(child.FQDN, parent.TTL)
+			if err == nil && len(records) != 0 {
+				finding.Message = fmt.Sprintf(
+					"overlaps managed zone %v, its records are pushed to both zones",
+					child.ID())
+			} else if child.Visibility == "public" {
+				finding.Message = fmt.Sprintf("managed zone %v is not delegated",
+					child.ID())
+			} else {
+				continue // private zones need no delegation
+			}
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+func checkPrivateAddresses(l *linter) []Finding {
+	return l.eachRecord(func(z zone, record *rrdb.Record) []Finding {
+		findings := []Finding{}
+		if z.Visibility != "public" ||
+			(record.RType != "A" && record.RType != "AAAA") {
+			return findings
+		}
+		for _, rdata := range record.RDatas {
+			ip := net.ParseIP(rdata)
+			for _, network := range privateNetworks {
+				if ip != nil && network.Contains(ip) {
+					findings = append(findings, newFinding(z, record,
+						"private address %v", rdata))
+					break
+				}
+			}
+		}
+		return findings
+	})
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,121 @@
+package lint
+
+import (
+	"path"
+	"testing"
+
+	"github.com/egymgmbh/dns-tools/config"
+	"github.com/egymgmbh/dns-tools/rrdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	directory := path.Join("testdata", "zonedata")
+	zones := path.Join(directory, "zones.yml")
+	dev := path.Join("testdata", "dev")
+	cfg := &config.Config{
+		ManagedZones: []config.ManagedZoneConfig{{
+			FQDN:              "example.com.",
+			TTL:               300,
+			Visibility:        "public",
+			ZoneDataDirectory: directory,
+		}, {
+			FQDN:              "blog.example.com.",
+			TTL:               300,
+			Visibility:        "public",
+			ZoneDataDirectory: directory,
+		}, {
+			FQDN:              "dev.example.com.",
+			TTL:               300,
+			Visibility:        "public",
+			ZoneDataDirectory: dev,
+		}},
+	}
+	dbs, err := rrdb.NewFromDirectories(cfg.ZoneDataDirectories())
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+
+	findings, err := Lint(cfg, dbs, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		zones + ":21:9: managed zone example.com.: www.example.com. CNAME: target web.example.com. does not exist (dangling-target)",
+		zones + ":24:9: managed zone example.com.: shop.example.com. MX: target alias.example.com. is an alias (mx-cname)",
+		zones + ":24:9: managed zone example.com.: shop.example.com. MX: no SPF record (mx-spf)",
+		zones + ":62:9: managed zone example.com.: app.example.com. CNAME: TTL 10 is too low (ttl-low)",
+		zones + ":50:9: managed zone example.com.: dual.example.com. AAAA: TTL 600 differs from A TTL 300 (ttl-inconsistent)",
+		zones + ":70:9: www.example.net.: not part of any managed zone using " +
+			directory + " (view \"\") (unmanaged)",
+		zones + ":75:5: exmaple.com.: zone is not managed using " + directory +
+			" (view \"\") (unmanaged)",
+		"managed zone example.com.: blog.example.com.: overlaps managed zone blog.example.com., its records are pushed to both zones (zone-overlap)",
+		"managed zone example.com.: dev.example.com.: managed zone dev.example.com. is not delegated (zone-overlap)",
+		zones + ":32:9: managed zone example.com.: db.example.com. A: private address 10.0.0.5 (private-address)",
+	}, findingStrings(findings))
+	severities := []rrdb.Severity{}
+	for _, finding := range findings {
+		severities = append(severities, finding.Severity)
+	}
+	assert.Equal(t, []rrdb.Severity{
+		rrdb.SeverityError, rrdb.SeverityError, rrdb.SeverityWarning,
+		rrdb.SeverityWarning, rrdb.SeverityWarning, rrdb.SeverityWarning,
+		rrdb.SeverityWarning, rrdb.SeverityError, rrdb.SeverityError,
+		rrdb.SeverityWarning,
+	}, severities)
+
+	// disabled rules
+	findings, err = Lint(cfg, dbs, []string{"mx-spf", "mx-cname", "dangling-target",
+		"ttl-low", "ttl-inconsistent", "unmanaged", "zone-overlap"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		zones + ":32:9: managed zone example.com.: db.example.com. A: private address 10.0.0.5 (private-address)",
+	}, findingStrings(findings))
+
+	// private zones may hold private addresses
+	cfg.ManagedZones[0].Visibility = "private"
+	findings, err = Lint(cfg, dbs, []string{"mx-spf", "mx-cname",
+		"dangling-target", "ttl-low", "ttl-inconsistent", "unmanaged", "zone-overlap"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{}, findingStrings(findings))
+
+	// nested private zones need no delegation but must not overlap
+	for idx := range cfg.ManagedZones {
+		cfg.ManagedZones[idx].Visibility = "private"
+	}
+	findings, err = Lint(cfg, dbs, []string{"mx-spf", "mx-cname",
+		"dangling-target", "ttl-low", "ttl-inconsistent", "unmanaged"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		"managed zone example.com. (private): blog.example.com.: overlaps managed zone blog.example.com. (private), its records are pushed to both zones (zone-overlap)",
+	}, findingStrings(findings))
+
+	_, err = Lint(cfg, dbs, []string{"foo"})
+	assert.Equal(t, "unknown lint rule: foo", err.Error())
+}
+
+func TestMXDMARC(t *testing.T) {
+	db := rrdb.New()
+	assert.Equal(t, nil, db.SetMX("example.com.", 0, []string{"10 mx.example.com."}))
+	assert.Equal(t, nil, db.SetMX("sub.example.com.", 0, []string{"10 mx.example.com."}))
+	records, err := db.Zone("example.com.", 300)
+	assert.Equal(t, nil, err)
+	l := &linter{zones: []zone{{
+		ManagedZoneConfig: config.ManagedZoneConfig{FQDN: "example.com.", TTL: 300},
+		db:                db,
+		records:           records,
+	}}}
+	assert.Equal(t, 2, len(checkMXDMARC(l)))
+
+	// policies of parent domains apply to subdomains
+	assert.Equal(t, nil, db.AddTXT("_dmarc.example.com.", 0, "v=DMARC1; p=none"))
+	assert.Equal(t, 0, len(checkMXDMARC(l)))
+}
+
+func findingStrings(findings []Finding) []string {
+	msgs := []string{}
+	for _, finding := range findings {
+		msgs = append(msgs, finding.String())
+	}
+	return msgs
+}
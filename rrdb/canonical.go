@@ -0,0 +1,248 @@
+package rrdb
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CanonicalRData converts the data of a record in presentation format to its
+// canonical form, so records can be compared regardless of how they were
+// written: Hostnames are lower-cased, addresses are formatted the way package
+// net does, the fields of MX and SOA records are separated by single spaces
+// and the strings of TXT records are quoted and escaped consistently. The
+// data of unsupported record types only has its whitespace normalized. Data
+// that can not be parsed is returned as is.
+func CanonicalRData(rtype, rdata string) string {
+	rt, err := lookupRType(rtype)
+	if err != nil || rt.canonical == nil {
+		return strings.Join(strings.Fields(rdata), " ")
+	}
+	return rt.canonical(rdata)
+}
+
+// CanonicalRDatas converts the data of an RRset in presentation format to its
+// canonical form and sorts it, see CanonicalRData and SortRDatas
+func CanonicalRDatas(rtype string, rdatas []string) []string {
+	out := []string{}
+	for _, rdata := range rdatas {
+		out = append(out, CanonicalRData(rtype, rdata))
+	}
+	SortRDatas(rtype, out)
+	return out
+}
+
+// SortRDatas sorts the data of an RRset in presentation format in canonical
+// order (RFC 4034 section 6.3), that is by the wire format of its canonical
+// form, e.g. MX records by numeric preference and addresses by their bytes.
+// Data of unsupported record types, or data that can not be parsed, is
+// compared as text.
+func SortRDatas(rtype string, rdatas []string) {
+	keys := make(map[string][]byte)
+	for _, rdata := range rdatas {
+		keys[rdata] = wireRData(rtype, rdata)
+	}
+	sort.SliceStable(rdatas, func(i, j int) bool {
+		return bytes.Compare(keys[rdatas[i]], keys[rdatas[j]]) < 0
+	})
+}
+
+// wireRData converts the data of a record to the wire format of its
+// canonical form
+func wireRData(rtype, rdata string) []byte {
+	rdata = CanonicalRData(rtype, rdata)
+	switch rtype {
+	case "A":
+		if ip := net.ParseIP(rdata).To4(); ip != nil {
+			return ip
+		}
+	case "AAAA":
+		if ip := net.ParseIP(rdata); ip != nil {
+			return ip.To16()
+		}
+	case "CNAME", "NS", "PTR":
+		return wireName(rdata)
+	case "MX":
+		fields := strings.Fields(rdata)
+		if len(fields) != 2 {
+			break
+		}
+		preference, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			break
+		}
+		return append([]byte{byte(preference >> 8), byte(preference)},
+			wireName(fields[1])...)
+	case "SOA":
+		soa, err := ParseSOA(rdata)
+		if err != nil {
+			break
+		}
+		wire := append(wireName(soa.MName), wireName(soa.RName)...)
+		for _, value := range []uint32{soa.Serial, uint32(soa.Refresh),
+			uint32(soa.Retry), uint32(soa.Expire), uint32(soa.Minimum)} {
+			wire = append(wire, byte(value>>24), byte(value>>16),
+				byte(value>>8), byte(value))
+		}
+		return wire
+	case "TXT":
+		strs, err := parseTXT(rdata)
+		if err != nil {
+			break
+		}
+		wire := []byte{}
+		for _, str := range strs {
+			wire = append(append(wire, byte(len(str))), str...)
+		}
+		return wire
+	}
+	return []byte(rdata)
+}
+
+// wireName converts a domain name to wire format: length-prefixed labels
+// terminated by the empty root label
+func wireName(name string) []byte {
+	wire := []byte{}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label != "" {
+			wire = append(append(wire, byte(len(label))), label...)
+		}
+	}
+	return append(wire, 0)
+}
+
+func canonicalName(rdata string) string {
+	return strings.ToLower(strings.TrimSpace(rdata))
+}
+
+func canonicalIP(rdata string) string {
+	ip := net.ParseIP(strings.TrimSpace(rdata))
+	if ip == nil {
+		return rdata
+	}
+	return ip.String()
+}
+
+func canonicalMX(rdata string) string {
+	fields := strings.Fields(rdata)
+	if len(fields) != 2 {
+		return rdata
+	}
+	preference, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return rdata
+	}
+	return fmt.Sprintf("%d %v", preference, strings.ToLower(fields[1]))
+}
+
+func canonicalSOA(rdata string) string {
+	soa, err := ParseSOA(rdata)
+	if err != nil {
+		return rdata
+	}
+	soa.MName = strings.ToLower(soa.MName)
+	soa.RName = strings.ToLower(soa.RName)
+	return soa.String()
+}
+
+func canonicalTXT(rdata string) string {
+	strs, err := parseTXT(rdata)
+	if err != nil {
+		return rdata
+	}
+	quoted := []string{}
+	for _, str := range strs {
+		quoted = append(quoted, quoteTXT(str))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteTXT converts a string of a TXT record to presentation format. Quotes
+// and backslashes are escaped, as well as control characters, which are
+// written as \DDD (RFC 1035 section 5.1).
+func quoteTXT(str string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for idx := 0; idx < len(str); idx++ {
+		c := str[idx]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// parseTXT splits the data of a TXT record in presentation format into its
+// strings. Strings are separated by whitespace and may be quoted.
+func parseTXT(rdata string) ([]string, error) {
+	strs := []string{}
+	idx := 0
+	for {
+		for idx < len(rdata) && (rdata[idx] == ' ' || rdata[idx] == '\t') {
+			idx++
+		}
+		if idx == len(rdata) {
+			return strs, nil
+		}
+		quoted := rdata[idx] == '"'
+		if quoted {
+			idx++
+		}
+		var b bytes.Buffer
+		for {
+			if idx == len(rdata) {
+				if quoted {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				break
+			}
+			c := rdata[idx]
+			if quoted && c == '"' {
+				idx++
+				break
+			}
+			if !quoted && (c == ' ' || c == '\t') {
+				break
+			}
+			if c == '\\' {
+				if idx+4 <= len(rdata) && isDigits(rdata[idx+1:idx+4]) {
+					value, _ := strconv.Atoi(rdata[idx+1 : idx+4])
+					if value > 255 {
+						return nil, fmt.Errorf("invalid escape: %v",
+							rdata[idx:idx+4])
+					}
+					b.WriteByte(byte(value))
+					idx += 4
+					continue
+				}
+				if idx+1 == len(rdata) {
+					return nil, fmt.Errorf("invalid escape at end of data")
+				}
+				idx++
+				c = rdata[idx]
+			}
+			b.WriteByte(c)
+			idx++
+		}
+		strs = append(strs, b.String())
+	}
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
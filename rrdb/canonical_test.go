@@ -0,0 +1,96 @@
+package rrdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalRData(t *testing.T) {
+	testCases := []struct {
+		rtype    string
+		in       string
+		expected string
+	}{
+		{"A", "192.0.2.1", "192.0.2.1"},
+		{"A", " 192.0.2.1", "192.0.2.1"},
+		{"AAAA", "2001:DB8::1", "2001:db8::1"},
+		{"AAAA", "2001:db8:0:0:0:0:0:1", "2001:db8::1"},
+		{"AAAA", "foo", "foo"},
+		{"NS", "NS1.Example.COM.", "ns1.example.com."},
+		{"CNAME", "WWW.example.com.", "www.example.com."},
+		{"MX", "010   MX.example.com.", "10 mx.example.com."},
+		{"MX", "0 .", "0 ."},
+		{"MX", "foo", "foo"},
+		{"SOA", "NS1.example.com.  Host\\.Master.example.com. 1 7200 900 1209600 300",
+			"ns1.example.com. host\\.master.example.com. 1 7200 900 1209600 300"},
+		{"TXT", `"v=spf1 -all"`, `"v=spf1 -all"`},
+		{"TXT", `v=spf1`, `"v=spf1"`},
+		{"TXT", `"foo"   "bar"`, `"foo" "bar"`},
+		{"TXT", `"a\"b\\c"`, `"a\"b\\c"`},
+		{"TXT", `"a\098c"`, `"abc"`},
+		{"TXT", `"tab\009"`, `"tab\009"`},
+		{"TXT", `"unterminated`, `"unterminated`},
+		{"CAA", "0  issue \"ca.example.net\"", "0 issue \"ca.example.net\""},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, CanonicalRData(tc.rtype, tc.in),
+			"%v %v", tc.rtype, tc.in)
+	}
+}
+
+func TestCanonicalRDatas(t *testing.T) {
+	assert.Equal(t, []string{"2001:db8::1", "2001:db8::2"},
+		CanonicalRDatas("AAAA", []string{"2001:DB8::2", "2001:db8:0::1"}))
+	assert.Equal(t, []string{}, CanonicalRDatas("A", nil))
+	assert.Equal(t, []string{"9 mx.example.com.", "10 mx.example.com."},
+		CanonicalRDatas("MX", []string{"10 MX.example.com.", "9 mx.example.com."}))
+}
+
+func TestSortRDatas(t *testing.T) {
+	for _, test := range []struct {
+		rtype  string
+		rdatas []string
+		sorted []string
+	}{
+		{"A", []string{"192.0.2.10", "192.0.2.9", "10.0.0.1"},
+			[]string{"10.0.0.1", "192.0.2.9", "192.0.2.10"}},
+		{"AAAA", []string{"2001:db8::10", "2001:db8::9"},
+			[]string{"2001:db8::9", "2001:db8::10"}},
+		{"MX", []string{"10 mx.example.com.", "9 mx.example.com.", "9 a.example.com."},
+			[]string{"9 a.example.com.", "9 mx.example.com.", "10 mx.example.com."}},
+		// labels are compared length first in wire format
+		{"NS", []string{"aa.example.com.", "b.example.com."},
+			[]string{"b.example.com.", "aa.example.com."}},
+		{"TXT", []string{`"bb"`, `"a" "c"`, `"c"`},
+			[]string{`"a" "c"`, `"c"`, `"bb"`}},
+		// unparsable data is compared as text
+		{"A", []string{"foo", "bar"}, []string{"bar", "foo"}},
+	} {
+		SortRDatas(test.rtype, test.rdatas)
+		assert.Equal(t, test.sorted, test.rdatas, test.rtype)
+	}
+}
+
+func TestStoreCanonical(t *testing.T) {
+	db := New()
+	assert.Equal(t, nil, db.SetAAAA(testFQDN, validTTL,
+		[]string{"2001:DB8::1"}))
+	record, err := db.AAAA(testFQDN, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"2001:db8::1"}, record.RDatas)
+
+	// duplicates are detected in canonical form
+	assert.Equal(t, "rdata: duplicate entry: 2001:db8::1",
+		db.ReplaceAAAA(testFQDN, validTTL,
+			[]string{"2001:db8::1", "2001:DB8:0::1"}).Error())
+	assert.Equal(t, "rdata: duplicate entry: mx.example.com.",
+		db.SetNS("ns."+testFQDN, validTTL,
+			[]string{"mx.example.com.", "MX.example.com."}).Error())
+
+	// TXT records are stored as is and quoted when retrieved
+	assert.Equal(t, nil, db.AddTXT(testFQDN, validTTL, "say \"hi\"\t"))
+	record, err = db.TXT(testFQDN, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{`"say \"hi\"\009"`}, record.RDatas)
+}
@@ -5,11 +5,10 @@ import (
 	"io/ioutil"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/egymgmbh/dns-tools/lib"
-
-	yaml "gopkg.in/yaml.v2"
 )
 
 // YAMLMailserver struct to load YAML data into: A singe mailserver with hostname
@@ -38,6 +37,7 @@ type yamlForwarding struct {
 type yamlDelegation struct {
 	TTL         int
 	Nameservers []string
+	ManagedZone bool // delegate to the managed zone of the name's FQDN instead
 }
 
 // YAMLAddresses struct to load YAML data into: A list of IP and legacy IP
@@ -56,23 +56,34 @@ type yamlTexts struct {
 
 // YAMLName struct to load YAML data into: A single name (label), may contain one
 // forwarding or one list of delegations or a combination of mailservers, texts
-// and addresses
+// and addresses. A name marked as override replaces the records earlier
+// definitions of the same name, e.g. from templates, hold for its record types.
 type yamlName struct {
 	Name        string
 	Description string
+	Views       []string // restricts the name to views, default: all views
+	Override    bool
 	Forwarding  yamlForwarding
 	Delegation  yamlDelegation
 	Mail        yamlMail
 	Texts       yamlTexts
 	Addresses   yamlAddresses
+	origin      []string // template chain the name stems from, innermost first
+	pos         position
+	sections    map[string]position // positions of the record sections
+	ignored     map[string][]string // ignored lint rules by section, "" for all
 }
 
-// YAMLTemplate struct to load YAML data into: A template containing names and an
-// optional description
+// YAMLTemplate struct to load YAML data into: A template containing names, an
+// optional description, optional parameters and optional included templates
 type yamlTemplate struct {
 	Template    string // Name of the template
 	Description string
+	Parameters  []yamlParameter
+	Templates   []yamlTemplateRef // included templates
 	Names       []yamlName
+	pos         position
+	broken      bool // template has errors and must not be instantiated
 }
 
 // YAMLZone struct to load YAML data into: A zone definition containing TTL,
@@ -80,15 +91,107 @@ type yamlTemplate struct {
 type yamlZone struct {
 	Zone        string // FQDN of the zone
 	Description string
+	Views       []string // restricts the zone to views, default: all views
 	TTL         int
-	Templates   []string
+	Templates   []yamlTemplateRef
 	Names       []yamlName
+	pos         position
 }
 
 // YAMLFile holds the full YAML file data
 type yamlFile struct {
-	Templates []yamlTemplate
-	Zones     []yamlZone
+	Variables         map[string][]string
+	Templates         []yamlTemplate
+	Zones             []yamlZone
+	variablePositions map[string]position
+}
+
+// loader holds the parsed zonedata files of a directory along with the
+// templates, variables and view names defined in them. Problems are recorded
+// in a collector and loading continues with the data that is usable.
+type loader struct {
+	yamlFileDatas map[string]yamlFile
+	templates     map[string]yamlTemplate
+	variables     map[string]yamlVariable
+	views         []string
+	used          map[string]bool // variables used by any zone
+	problems      *collector
+}
+
+// newLoader reads and parses all YAML-formatted zonedata files of a directory
+// and builds the templates and variables defined in them
+func newLoader(directory string, problems *collector) *loader {
+	yamlFileDatas := loadDirectory(directory, problems)
+	templates, views := buildTemplates(yamlFileDatas, problems)
+	variables := buildVariables(yamlFileDatas, problems)
+	return &loader{
+		yamlFileDatas: yamlFileDatas,
+		templates:     templates,
+		variables:     variables,
+		views:         views,
+		used:          make(map[string]bool),
+		problems:      problems,
+	}
+}
+
+// resolveZone resolves templates, overrides and variables of a zone. The
+// result holds the names of a single view. Names referring to undefined
+// variables are left out.
+func (l *loader) resolveZone(zone yamlZone, view string) []yamlName {
+	names := resolveZone(zone, l.templates, view, l.problems)
+	expanded := []yamlName{}
+	for _, name := range names {
+		name, used, err := name.expand(l.variables, zone)
+		if err != nil {
+			l.problems.add(err)
+			continue
+		}
+		for _, variables := range used {
+			for _, variable := range variables {
+				l.used[variable] = true
+			}
+		}
+		expanded = append(expanded, name)
+	}
+	return expanded
+}
+
+// warnUnused records warnings for templates that are neither used by zones nor
+// included by other templates, and for variables that are not used by any
+// zone. The views have to be built before.
+func (l *loader) warnUnused() {
+	used := make(map[string]bool)
+	for _, fname := range l.fnames() {
+		yamlFileData := l.yamlFileDatas[fname]
+		for _, template := range yamlFileData.Templates {
+			for _, ref := range template.Templates {
+				used[ref.Template] = true
+			}
+		}
+		for _, zone := range yamlFileData.Zones {
+			for _, ref := range zone.Templates {
+				used[ref.Template] = true
+			}
+		}
+	}
+	for _, fname := range l.fnames() {
+		for _, template := range l.yamlFileDatas[fname].Templates {
+			if !used[template.Template] {
+				l.problems.warnf(template.pos, "template %v: unused",
+					template.Template)
+			}
+		}
+	}
+	names := []string{}
+	for name := range l.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !l.used[name] {
+			l.problems.warnf(l.variables[name].pos, "variable %v: unused", name)
+		}
+	}
 }
 
 func (db *RRDB) loadNS(fqdn string, delegation yamlDelegation) error {
@@ -96,6 +199,12 @@ func (db *RRDB) loadNS(fqdn string, delegation yamlDelegation) error {
 	for _, rdata := range delegation.Nameservers {
 		rdatas = append(rdatas, strings.TrimSpace(rdata))
 	}
+	if delegation.ManagedZone {
+		if len(rdatas) != 0 {
+			return fmt.Errorf("nameservers of a managed zone can not be set")
+		}
+		return db.SetManagedDelegation(fqdn, delegation.TTL)
+	}
 	if len(rdatas) == 0 {
 		return nil
 	}
@@ -192,100 +301,377 @@ func (db *RRDB) loadAddresses(fqdn string, addresses yamlAddresses) error {
 //     addresses:                        | E     |
 //       literals:                       |       |
 //       - 2001:db8:cafe::1             ,/      ,/
-func (db *RRDB) loadNames(names []yamlName, zone yamlZone) error {
+func (db *RRDB) loadNames(names []yamlName, zone yamlZone, view string,
+	defined map[string][]position, problems *collector) {
 	for _, name := range names {
-		fqdn := lib.MakeFQDN(name.Name, zone.Zone)
-		err := db.loadNS(fqdn, name.Delegation)
-		if err != nil {
-			return fmt.Errorf("zone %v: name %v: load delegations: %v",
-				zone.Zone, name.Name, err)
+		if !inView(name.Views, view) {
+			continue
 		}
-		err = db.loadMX(fqdn, name.Mail)
-		if err != nil {
-			return fmt.Errorf("zone %v: name %v: load mailservers: %v",
-				zone.Zone, name.Name, err)
+		fqdn := lib.MakeFQDN(name.Name, zone.Zone)
+		check := func(section, action string, err error) {
+			if err == nil {
+				return
+			}
+			previously := ""
+			if len(defined[fqdn]) != 0 {
+				positions := []string{}
+				for _, pos := range defined[fqdn] {
+					positions = append(positions, pos.String())
+				}
+				previously = fmt.Sprintf(" (previously defined at %v)",
+					strings.Join(positions, ", "))
+			}
+			pos, where := name.where(zone, section)
+			problems.add(errorf(pos, "%v: %v: %v%v", where, action, err,
+				previously))
 		}
-		err = db.loadTXT(fqdn, name.Texts)
-		if err != nil {
-			return fmt.Errorf("zone %v: name %v: load texts: %v",
-				zone.Zone, name.Name, err)
+		check("delegation", "load delegations", db.loadNS(fqdn, name.Delegation))
+		check("mail", "load mailservers", db.loadMX(fqdn, name.Mail))
+		check("texts", "load texts", db.loadTXT(fqdn, name.Texts))
+		check("forwarding", "load forwarding", db.loadCNAME(fqdn, name.Forwarding))
+		check("addresses", "load addresses", db.loadAddresses(fqdn, name.Addresses))
+		if len(name.rtypes()) != 0 {
+			defined[fqdn] = append(defined[fqdn], name.pos)
 		}
-		err = db.loadCNAME(fqdn, name.Forwarding)
-		if err != nil {
-			return fmt.Errorf("zone %v: name %v: load forwarding: %v",
-				zone.Zone, name.Name, err)
+	}
+}
+
+// where describes the location of a name, or of one of its record sections,
+// for error messages. Names stemming from templates are described along with
+// the template chain and the zone instantiating them.
+func (name yamlName) where(zone yamlZone, section string) (position, string) {
+	pos, ok := name.sections[section]
+	if !ok {
+		pos = name.pos
+	}
+	if len(name.origin) == 0 {
+		return pos, fmt.Sprintf("zone %v: name %v", zone.Zone, name.Name)
+	}
+	return pos, fmt.Sprintf("zone %v (%v): template %v: name %v", zone.Zone,
+		zone.pos, strings.Join(name.origin, " via "), name.Name)
+}
+
+// Views holds one database per view. The default view has an empty name and
+// holds all data that is not restricted to particular views.
+type Views map[string]*RRDB
+
+// View returns the database of a view
+func (views Views) View(name string) (*RRDB, error) {
+	db, ok := views[name]
+	if !ok {
+		return nil, fmt.Errorf("view %q not found", name)
+	}
+	return db, nil
+}
+
+// inView checks if data restricted to a list of views is part of a view.
+// Unrestricted data is part of every view.
+func inView(views []string, view string) bool {
+	if len(views) == 0 {
+		return true
+	}
+	for _, v := range views {
+		if v == view {
+			return true
 		}
-		err = db.loadAddresses(fqdn, name.Addresses)
-		if err != nil {
-			return fmt.Errorf("zone %v: name %v: load addresses: %v",
-				zone.Zone, name.Name, err)
+	}
+	return false
+}
+
+func checkViews(views []string) error {
+	for _, view := range views {
+		if err := lib.IsValidView(view); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// NewFromDirectory creates a new database from a directory of YAML-formatted
-// zonedata files
-func NewFromDirectory(directory string) (*RRDB, error) {
+// loadDirectory reads and parses all YAML-formatted zonedata files of a
+// directory. Files that can not be read or parsed are left out.
+func loadDirectory(directory string, problems *collector) map[string]yamlFile {
+	yamlFileDatas := make(map[string]yamlFile)
 	fnames, err := filepath.Glob(path.Join(directory, "*.yml"))
 	if err != nil {
-		return nil, err
+		problems.add(err)
+		return yamlFileDatas
 	}
-
-	yamlFileDatas := make(map[string]yamlFile)
 	for _, fname := range fnames {
-		data, ferr := ioutil.ReadFile(fname)
-		if ferr != nil {
-			return nil, ferr
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			problems.add(err)
+			continue
 		}
-		yamlFileData := yamlFile{}
-		ferr = yaml.UnmarshalStrict(data, &yamlFileData)
-		if ferr != nil {
-			return nil, fmt.Errorf("file %v: %v", fname, ferr)
+		yamlFileData, err := parseFile(fname, data)
+		if err != nil {
+			problems.add(err)
+			continue
 		}
 		yamlFileDatas[fname] = yamlFileData
 	}
+	return yamlFileDatas
+}
 
-	// build templates map
+// buildTemplates builds the templates map and collects the names of all views
+// that are used in the zonedata. Templates with errors are marked as broken.
+func buildTemplates(yamlFileDatas map[string]yamlFile,
+	problems *collector) (map[string]yamlTemplate, []string) {
 	templates := make(map[string]yamlTemplate)
-	for fname, yamlFileData := range yamlFileDatas {
+	seenViews := make(map[string]bool)
+	addViews := func(views []string) {
+		for _, view := range views {
+			seenViews[view] = true
+		}
+	}
+	for _, fname := range sortedFnames(yamlFileDatas) {
+		yamlFileData := yamlFileDatas[fname]
 		for _, template := range yamlFileData.Templates {
-			if _, seen := templates[template.Template]; seen {
-				return nil, fmt.Errorf("file %v: template %v: duplicate",
-					fname, template)
+			if seen, ok := templates[template.Template]; ok {
+				problems.add(errorf(template.pos,
+					"template %v: duplicate, already defined at %v",
+					template.Template, seen.pos))
+				continue
+			}
+			if len(template.Names) == 0 && len(template.Templates) == 0 {
+				problems.add(errorf(template.pos, "template %v: empty",
+					template.Template))
+				template.broken = true
+			}
+			err := template.check()
+			if err != nil {
+				problems.add(err)
+				template.broken = true
 			}
-			if len(template.Names) == 0 {
-				return nil, fmt.Errorf("file %v: template %v: empty",
-					fname, template)
+			for _, name := range template.Names {
+				err = checkViews(name.Views)
+				if err != nil {
+					problems.add(errorf(name.pos, "template %v: name %v: %v",
+						template.Template, name.Name, err))
+					template.broken = true
+				}
+				addViews(name.Views)
 			}
 			templates[template.Template] = template // uh-oh, naming is fun :)
 		}
-	}
-
-	db := New()
-	for fname, yamlFileData := range yamlFileDatas {
 		for _, zone := range yamlFileData.Zones {
-			// templates
-			for _, template := range zone.Templates {
-				if _, ok := templates[template]; !ok {
-					return nil, fmt.Errorf("file %v: zone %v: template %v: not found",
-						fname, zone.Zone, template)
-				}
-				err = db.loadNames(templates[template].Names, zone)
+			err := checkViews(zone.Views)
+			if err != nil {
+				problems.add(errorf(zone.pos, "zone %v: %v", zone.Zone, err))
+			}
+			addViews(zone.Views)
+			for _, name := range zone.Names {
+				err = checkViews(name.Views)
 				if err != nil {
-					return nil, fmt.Errorf("file %v: template %v: %v",
-						fname, template, err)
+					problems.add(errorf(name.pos, "zone %v: name %v: %v",
+						zone.Zone, name.Name, err))
 				}
+				addViews(name.Views)
 			}
-			// zone entries
-			err = db.loadNames(zone.Names, zone)
-			if err != nil {
-				return nil, fmt.Errorf("file %v: %v", fname, err)
+		}
+	}
+	checkIncludes(templates, problems)
+	views := []string{}
+	for view := range seenViews {
+		if lib.IsValidView(view) == nil {
+			views = append(views, view)
+		}
+	}
+	sort.Strings(views)
+	return templates, views
+}
+
+// buildView creates the database of a single view from all data that can be
+// loaded
+func (l *loader) buildView(view string) *RRDB {
+	l.problems.view = view
+	defer func() { l.problems.view = "" }()
+	db := New()
+	defined := make(map[string][]position)
+	for _, fname := range l.fnames() {
+		for _, zone := range l.yamlFileDatas[fname].Zones {
+			if !inView(zone.Views, view) {
+				continue
 			}
+			names := l.resolveZone(zone, view)
+			db.loadNames(names, zone, view, defined, l.problems)
+		}
+	}
+	return db
+}
+
+// buildViews creates the databases of all views
+func (l *loader) buildViews() Views {
+	views := make(Views)
+	for _, view := range append([]string{""}, l.views...) {
+		views[view] = l.buildView(view)
+	}
+	return views
+}
+
+// empty checks if all views are empty
+func (views Views) empty() bool {
+	for _, db := range views {
+		if len(db.root.children) != 0 {
+			return false
 		}
 	}
+	return true
+}
+
+// NewFromDirectory creates a new database from a directory of YAML-formatted
+// zonedata files. The database holds the default view only, see
+// NewViewsFromDirectory for split-horizon zonedata.
+func NewFromDirectory(directory string) (*RRDB, error) {
+	problems := newCollector()
+	l := newLoader(directory, problems)
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	db := l.buildView("")
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
 	if len(db.root.children) == 0 {
 		return nil, fmt.Errorf("empty database")
 	}
 	return db, nil
 }
+
+// NewViewsFromDirectory creates one database per view from a directory of
+// YAML-formatted zonedata files. Names and zones can be restricted to views;
+// unrestricted names and zones are part of every view, including the default
+// view.
+func NewViewsFromDirectory(directory string) (Views, error) {
+	problems := newCollector()
+	l := newLoader(directory, problems)
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	views := l.buildViews()
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	if views.empty() {
+		return nil, fmt.Errorf("empty database")
+	}
+	return views, nil
+}
+
+// Origin describes where the records of a name come from after resolving
+// templates and overrides
+type Origin struct {
+	FQDN         string
+	RTypes       []string // record types the definition contributes
+	Position     string   // file:line:col of the name's definition
+	Zone         string
+	ZonePosition string              // file:line:col of the zone
+	Templates    []string            // template chain, innermost first, empty for zone names
+	Ignored      map[string][]string // ignored lint rules by record type
+}
+
+// fnames returns the names of the loaded files in loading order
+func (l *loader) fnames() []string {
+	return sortedFnames(l.yamlFileDatas)
+}
+
+// describe returns the origin of a name of a zone
+func (name yamlName) describe(zone yamlZone, rtypes []string) Origin {
+	var ignored map[string][]string
+	for _, rtype := range rtypes {
+		rules := append([]string(nil), name.ignored[""]...)
+		for _, section := range sections {
+			for _, sectionRType := range sectionRTypes[section] {
+				if sectionRType == rtype {
+					rules = append(rules, name.ignored[section]...)
+				}
+			}
+		}
+		if len(rules) != 0 {
+			if ignored == nil {
+				ignored = make(map[string][]string)
+			}
+			ignored[rtype] = rules
+		}
+	}
+	return Origin{
+		FQDN:         lib.MakeFQDN(name.Name, zone.Zone),
+		RTypes:       rtypes,
+		Position:     name.pos.String(),
+		Zone:         zone.Zone,
+		ZonePosition: zone.pos.String(),
+		Templates:    name.origin,
+		Ignored:      ignored,
+	}
+}
+
+// OriginsFromDirectory resolves the templates and overrides of a directory of
+// YAML-formatted zonedata files for a view and returns the origin of every name
+// definition in loading order. Definitions replaced entirely by overrides are
+// omitted.
+func OriginsFromDirectory(directory, view string) ([]Origin, error) {
+	problems := newCollector()
+	l := newLoader(directory, problems)
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	origins := []Origin{}
+	for _, fname := range l.fnames() {
+		for _, zone := range l.yamlFileDatas[fname].Zones {
+			if !inView(zone.Views, view) {
+				continue
+			}
+			names := l.resolveZone(zone, view)
+			if err := problems.err(); err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				rtypes := name.rtypes()
+				if len(rtypes) == 0 {
+					continue
+				}
+				origins = append(origins, name.describe(zone, rtypes))
+			}
+		}
+	}
+	return origins, nil
+}
+
+// NewFromDirectories creates the views of every directory of YAML-formatted
+// zonedata files. The views are returned in a map with the directory as key.
+func NewFromDirectories(directories []string) (map[string]Views, error) {
+	dbs := make(map[string]Views)
+	for _, directory := range directories {
+		if _, ok := dbs[directory]; ok {
+			continue
+		}
+		views, err := NewViewsFromDirectory(directory)
+		if err != nil {
+			return nil, fmt.Errorf("directory %v: %v", directory, err)
+		}
+		dbs[directory] = views
+	}
+	return dbs, nil
+}
+
+// CheckDirectories loads the views of every directory of YAML-formatted
+// zonedata files like NewFromDirectories, but keeps going past errors. It
+// returns the views built from all data that could be loaded, along with every
+// problem found, including warnings about unused templates and variables. The
+// views are incomplete if any of the problems is an error.
+func CheckDirectories(directories []string) (map[string]Views, Problems) {
+	problems := newCollector()
+	dbs := make(map[string]Views)
+	for _, directory := range directories {
+		if _, ok := dbs[directory]; ok {
+			continue
+		}
+		l := newLoader(directory, problems)
+		views := l.buildViews()
+		if views.empty() {
+			problems.add(errorf(position{file: directory}, "empty database"))
+		}
+		l.warnUnused()
+		dbs[directory] = views
+	}
+	return dbs, problems.problems
+}
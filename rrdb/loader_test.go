@@ -3,6 +3,7 @@ package rrdb
 import (
 	"io/ioutil"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,9 +19,31 @@ func TestNewFromDirectoryFail(t *testing.T) {
 		if !dentry.IsDir() {
 			continue
 		}
-		db, err := NewFromDirectory(path.Join("testdata", "fail", dentry.Name()))
+		directory := path.Join("testdata", "fail", dentry.Name())
+		db, err := NewFromDirectory(directory)
 		assert.Equal(t, (*RRDB)(nil), db, dentry.Name())
 		assert.NotEqual(t, nil, err, dentry.Name())
+		// errors point to the file they stem from
+		if err != nil && err.Error() != "empty database" {
+			assert.True(t, strings.HasPrefix(err.Error(), directory+"/"),
+				err.Error())
+		}
+	}
+}
+
+func TestNewFromDirectoryConflict(t *testing.T) {
+	// the name of the later file is blamed, no matter in which order the
+	// files are read
+	for i := 0; i < 10; i++ {
+		directory := path.Join("testdata", "fail", "name-conflict")
+		_, err := NewFromDirectory(directory)
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, path.Join(directory, "b.yml")+":7:11: zone example.com.: "+
+				"name www: load forwarding: conflicting records "+
+				"(previously defined at "+path.Join(directory, "a.yml")+":5:9)",
+				err.Error())
+		}
 	}
 }
 
@@ -39,3 +62,237 @@ func TestNewFromDirectoryPass(t *testing.T) {
 		assert.Equal(t, nil, err, dentry.Name())
 	}
 }
+
+func TestNewFromDirectories(t *testing.T) {
+	{
+		dirs := []string{
+			path.Join("testdata", "pass", "simple-zone"),
+			path.Join("testdata", "pass", "null-mailer"),
+			path.Join("testdata", "pass", "simple-zone"),
+		}
+		dbs, err := NewFromDirectories(dirs)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 2, len(dbs))
+		for _, dir := range dirs {
+			assert.NotEqual(t, Views(nil), dbs[dir], dir)
+		}
+	}
+	{
+		dbs, err := NewFromDirectories([]string{
+			path.Join("testdata", "pass", "simple-zone"),
+			path.Join("testdata", "fail", "yaml-invalid"),
+		})
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, map[string]Views(nil), dbs)
+	}
+}
+
+func TestNewViewsFromDirectory(t *testing.T) {
+	views, err := NewViewsFromDirectory(path.Join("testdata", "pass", "views"))
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	assert.Equal(t, 3, len(views))
+	// default view: unrestricted data only
+	{
+		db, err := views.View("")
+		assert.Equal(t, nil, err)
+		records, err := db.Zone("example.com.", 300)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 1, len(records))
+		_, err = db.Zone("corp.example.com.", 300)
+		assert.NotEqual(t, nil, err)
+	}
+	// internal view
+	{
+		db, err := views.View("internal")
+		assert.Equal(t, nil, err)
+		record, err := db.A("www.example.com.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"10.0.0.1"}, record.RDatas)
+		}
+		_, err = db.A("intranet.corp.example.com.", 300)
+		assert.Equal(t, nil, err)
+		_, err = db.A("example.com.", 300)
+		assert.Equal(t, nil, err)
+	}
+	// external view
+	{
+		db, err := views.View("external")
+		assert.Equal(t, nil, err)
+		record, err := db.A("www.example.com.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"192.0.2.1"}, record.RDatas)
+		}
+		_, err = db.Zone("corp.example.com.", 300)
+		assert.NotEqual(t, nil, err)
+	}
+	// unknown view
+	{
+		db, err := views.View("dmz")
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, (*RRDB)(nil), db)
+	}
+}
+
+func TestNewFromDirectoryTemplateParameters(t *testing.T) {
+	db, err := NewFromDirectory(path.Join("testdata", "pass", "template-parameters"))
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	// defaults
+	{
+		record, err := db.MX("example.com.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"10 mx.example.net."}, record.RDatas)
+		}
+		record, err = db.CNAME("webmail.example.com.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"www.example.com."}, record.RDatas)
+		}
+	}
+	// overrides
+	{
+		record, err := db.MX("example.org.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"10 mx.example.org."}, record.RDatas)
+		}
+		record, err = db.TXT("example.org.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{`"v=spf1 include:_spf.example.org ~all"`},
+				record.RDatas)
+		}
+	}
+}
+
+func TestNewFromDirectoryTemplateComposition(t *testing.T) {
+	db, err := NewFromDirectory(path.Join("testdata", "pass", "template-composition"))
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	// zone overrides
+	{
+		record, err := db.MX("example.com.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"10 mx1.example.com.", "20 mx2.example.com."},
+				record.RDatas)
+		}
+		record, err = db.TXT("example.com.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{`"v=spf1 include:_spf.example.com. ~all"`},
+				record.RDatas)
+		}
+		_, err = db.CNAME("www.example.com.", 300)
+		assert.NotEqual(t, nil, err)
+		record, err = db.A("www.example.com.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"192.0.2.81"}, record.RDatas)
+		}
+	}
+	// templates only
+	{
+		record, err := db.MX("example.org.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"10 mx.example.net."}, record.RDatas)
+		}
+		record, err = db.CNAME("www.example.org.", 300)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, []string{"example.org."}, record.RDatas)
+		}
+	}
+}
+
+func TestOriginsFromDirectory(t *testing.T) {
+	directory := path.Join("testdata", "pass", "template-composition")
+	origins, err := OriginsFromDirectory(directory, "")
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	zones := path.Join(directory, "zones.yml")
+	templates := path.Join(directory, "templates.yml")
+	spf := "spf (" + templates + ":3:5)"
+	mail := "mail (" + templates + ":12:5)"
+	web := "web (" + templates + ":27:5)"
+	assert.Equal(t, []Origin{
+		{"example.com.", []string{"TXT"}, templates + ":7:9", "example.com.",
+			zones + ":3:5", []string{spf, mail}, nil},
+		{"example.com.", []string{"A"}, templates + ":29:9", "example.com.",
+			zones + ":3:5", []string{web}, nil},
+		{"example.com.", []string{"MX"}, zones + ":8:9", "example.com.",
+			zones + ":3:5", nil, nil},
+		{"www.example.com.", []string{"A"}, zones + ":16:9", "example.com.",
+			zones + ":3:5", nil, nil},
+		{"example.org.", []string{"TXT"}, templates + ":7:9", "example.org.",
+			zones + ":21:5", []string{spf, mail}, nil},
+		{"example.org.", []string{"MX"}, templates + ":21:9", "example.org.",
+			zones + ":21:5", []string{mail}, nil},
+		{"example.org.", []string{"A"}, templates + ":29:9", "example.org.",
+			zones + ":21:5", []string{web}, nil},
+		{"www.example.org.", []string{"CNAME"}, templates + ":33:9",
+			"example.org.", zones + ":21:5", []string{web}, nil},
+	}, origins)
+}
+
+func TestNewFromDirectoryManagedDelegation(t *testing.T) {
+	db, err := NewFromDirectory(path.Join("testdata", "pass",
+		"managed-delegation"))
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	records, err := db.Zone("example.com.", 300)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, helperCompareRecords([]*Record{
+		{FQDN: "www.example.com.", RType: "A", TTL: 300,
+			RDatas: []string{"192.0.2.1"}},
+		{FQDN: "sub.example.com.", RType: "NS", TTL: 3600},
+	}, records))
+	records, err = db.Zone("sub.example.com.", 300)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, helperCompareRecords([]*Record{
+		{FQDN: "sub.example.com.", RType: "A", TTL: 300,
+			RDatas: []string{"192.0.2.2"}},
+		{FQDN: "www.sub.example.com.", RType: "CNAME", TTL: 300,
+			RDatas: []string{"sub.example.com."}},
+	}, records))
+}
+
+func TestNewFromDirectoryVariables(t *testing.T) {
+	db, err := NewFromDirectory(path.Join("testdata", "pass", "variables"))
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	record, err := db.A("example.com.", 300)
+	assert.Equal(t, nil, err)
+	if err == nil {
+		assert.Equal(t, []string{"192.0.2.10", "192.0.2.11", "198.51.100.10",
+			"192.0.2.1"}, record.RDatas)
+	}
+	record, err = db.AAAA("www.example.com.", 300)
+	assert.Equal(t, nil, err)
+	if err == nil {
+		assert.Equal(t, []string{"2001:db8::10"}, record.RDatas)
+	}
+	record, err = db.NS("sub.example.com.", 300)
+	assert.Equal(t, nil, err)
+	if err == nil {
+		assert.Equal(t, []string{"ns1.example.net.", "ns2.example.net."},
+			record.RDatas)
+	}
+}
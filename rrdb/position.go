@@ -0,0 +1,273 @@
+package rrdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+var (
+	regexYAMLLine = regexp.MustCompile(`^(yaml: )?line ([0-9]+): (.*)$`)
+	regexIgnore   = regexp.MustCompile(`^#\s*dbcheck:ignore\s+(.*)$`)
+)
+
+// position identifies a location in a zonedata file
+type position struct {
+	file   string
+	line   int
+	column int
+}
+
+// String formats a position as file:line:col, or just the file name if the
+// location within the file is unknown
+func (pos position) String() string {
+	if pos.line == 0 {
+		return pos.file
+	}
+	return fmt.Sprintf("%v:%v:%v", pos.file, pos.line, pos.column)
+}
+
+// yamlError prefixes the messages of YAML parser errors with the file and
+// line they refer to, e.g. "yaml: line 3: found character that cannot start
+// any token" becomes "test.yml:3: yaml: found character that cannot start any
+// token"
+func yamlError(fname string, err error) error {
+	format := func(msg string) *Problem {
+		problem := &Problem{
+			Position: fname,
+			Severity: SeverityError,
+			Message:  msg,
+		}
+		match := regexYAMLLine.FindStringSubmatch(msg)
+		if match != nil {
+			problem.Position = fmt.Sprintf("%v:%v", fname, match[2])
+			problem.Message = "yaml: " + match[3]
+		}
+		return problem
+	}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		problems := Problems{}
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, format(msg))
+		}
+		return problems
+	}
+	return format(err.Error())
+}
+
+// sortedFnames returns the names of the loaded files in lexical order, which is
+// the order in which their data is loaded
+func sortedFnames(yamlFileDatas map[string]yamlFile) []string {
+	fnames := []string{}
+	for fname := range yamlFileDatas {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+	return fnames
+}
+
+// yamlNode wraps a YAML node to look up the positions of decoded data
+type yamlNode struct {
+	*yaml.Node
+}
+
+// value returns the value of a key if the node is a mapping
+func (nd yamlNode) value(key string) yamlNode {
+	return nd.lookup(key, 1)
+}
+
+// key returns the node of a key itself if the node is a mapping
+func (nd yamlNode) key(key string) yamlNode {
+	return nd.lookup(key, 0)
+}
+
+// lookup returns the node of a key (offset 0) or its value (offset 1) if the
+// node is a mapping. Keys of the mapping itself take precedence over keys
+// merged into it with "<<", like the decoder does it.
+func (nd yamlNode) lookup(key string, offset int) yamlNode {
+	if nd.Node == nil || nd.Kind != yaml.MappingNode {
+		return yamlNode{}
+	}
+	merged := []yamlNode{}
+	for idx := 0; idx+1 < len(nd.Content); idx += 2 {
+		if nd.Content[idx].Value == key {
+			return yamlNode{nd.Content[idx+offset]}.resolve()
+		}
+		if nd.Content[idx].Tag == "!!merge" {
+			value := yamlNode{nd.Content[idx+1]}.resolve()
+			if value.Kind == yaml.SequenceNode {
+				for item := range value.Content {
+					merged = append(merged, value.item(item))
+				}
+			} else {
+				merged = append(merged, value)
+			}
+		}
+	}
+	for _, mapping := range merged {
+		if found := mapping.lookup(key, offset); found.Node != nil {
+			return found
+		}
+	}
+	return yamlNode{}
+}
+
+// item returns an item of the node if the node is a sequence
+func (nd yamlNode) item(idx int) yamlNode {
+	if nd.Node == nil || nd.Kind != yaml.SequenceNode ||
+		idx >= len(nd.Content) {
+		return yamlNode{}
+	}
+	return yamlNode{nd.Content[idx]}.resolve()
+}
+
+// resolve follows aliases
+func (nd yamlNode) resolve() yamlNode {
+	for nd.Node != nil && nd.Kind == yaml.AliasNode {
+		nd = yamlNode{nd.Alias}
+	}
+	return nd
+}
+
+// position returns the position of the node within a file. The position is
+// limited to the file if the node is unknown.
+func (nd yamlNode) position(fname string) position {
+	if nd.Node == nil {
+		return position{file: fname}
+	}
+	return position{file: fname, line: nd.Line, column: nd.Column}
+}
+
+// sections lists the keys of a name holding records
+var sections = []string{"delegation", "mail", "texts", "forwarding", "addresses"}
+
+// sectionRTypes maps the sections of a name to the record types they define
+var sectionRTypes = map[string][]string{
+	"delegation": {"NS"},
+	"mail":       {"MX"},
+	"texts":      {"TXT"},
+	"forwarding": {"CNAME"},
+	"addresses":  {"A", "AAAA"},
+}
+
+// ignoredRules returns the lint rules listed in comments like
+// "# dbcheck:ignore mx-spf mx-dmarc" of nodes. With deep set, the comments of
+// all nodes below the nodes are considered as well.
+func ignoredRules(deep bool, nodes ...yamlNode) []string {
+	rules := []string{}
+	for _, nd := range nodes {
+		if nd.Node == nil {
+			continue
+		}
+		comments := nd.HeadComment + "\n" + nd.LineComment
+		for _, comment := range strings.Split(comments, "\n") {
+			match := regexIgnore.FindStringSubmatch(strings.TrimSpace(comment))
+			if match != nil {
+				rules = append(rules, strings.FieldsFunc(match[1], func(r rune) bool {
+					return r == ',' || r == ' ' || r == '\t'
+				})...)
+			}
+		}
+		if deep {
+			for _, child := range nd.Content {
+				rules = append(rules, ignoredRules(true, yamlNode{child})...)
+			}
+		}
+	}
+	return rules
+}
+
+// locateNames sets the positions of names and their record sections and picks
+// up the lint rules ignored for them
+func locateNames(fname string, nd yamlNode, names []yamlName) {
+	for idx := range names {
+		item := nd.item(idx)
+		names[idx].pos = item.position(fname)
+		names[idx].sections = make(map[string]position)
+		names[idx].ignored = make(map[string][]string)
+		rules := ignoredRules(false, item, item.key("name"), item.value("name"))
+		if len(rules) != 0 {
+			names[idx].ignored[""] = rules
+		}
+		for _, section := range sections {
+			if value := item.value(section); value.Node != nil {
+				names[idx].sections[section] = value.position(fname)
+				rules := ignoredRules(true, item.key(section), value)
+				if len(rules) != 0 {
+					names[idx].ignored[section] = rules
+				}
+			}
+		}
+	}
+}
+
+// locateRefs sets the positions of template references
+func locateRefs(fname string, nd yamlNode, refs []yamlTemplateRef) {
+	for idx := range refs {
+		refs[idx].pos = nd.item(idx).position(fname)
+	}
+}
+
+// parseFile decodes the data of a zonedata file and keeps the positions of
+// templates, zones, names and variables, see locate. Unknown keys are errors.
+func parseFile(fname string, data []byte) (yamlFile, error) {
+	yamlFileData := yamlFile{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&yamlFileData)
+	if err != nil && err != io.EOF { // EOF: empty document
+		return yamlFile{}, yamlError(fname, err)
+	}
+	err = locate(fname, data, &yamlFileData)
+	if err != nil {
+		return yamlFile{}, yamlError(fname, err)
+	}
+	return yamlFileData, nil
+}
+
+// locate parses the data of a file into YAML nodes to keep the positions of
+// templates, zones, names and variables. The data has to be decoded into
+// yamlFileData before. Decoded data and nodes are matched up by sequence index
+// and mapping key. Both stem from the same parser, which expands aliases and
+// merge keys the same way, so the positions of data defined with anchors are
+// those of the anchored nodes.
+func locate(fname string, data []byte, yamlFileData *yamlFile) error {
+	var document yaml.Node
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return err
+	}
+	root := yamlNode{}
+	if len(document.Content) != 0 {
+		root = yamlNode{document.Content[0]}.resolve()
+	}
+
+	templates := root.value("templates")
+	for idx := range yamlFileData.Templates {
+		template := &yamlFileData.Templates[idx]
+		item := templates.item(idx)
+		template.pos = item.position(fname)
+		locateRefs(fname, item.value("templates"), template.Templates)
+		locateNames(fname, item.value("names"), template.Names)
+	}
+	zones := root.value("zones")
+	for idx := range yamlFileData.Zones {
+		zone := &yamlFileData.Zones[idx]
+		item := zones.item(idx)
+		zone.pos = item.position(fname)
+		locateRefs(fname, item.value("templates"), zone.Templates)
+		locateNames(fname, item.value("names"), zone.Names)
+	}
+	variables := root.value("variables")
+	yamlFileData.variablePositions = make(map[string]position)
+	for name := range yamlFileData.Variables {
+		yamlFileData.variablePositions[name] =
+			variables.key(name).position(fname)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package rrdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestPositionString(t *testing.T) {
+	assert.Equal(t, "test.yml", position{file: "test.yml"}.String())
+	assert.Equal(t, "test.yml:3:5",
+		position{file: "test.yml", line: 3, column: 5}.String())
+}
+
+func TestYAMLError(t *testing.T) {
+	assert.Equal(t,
+		"test.yml:3: yaml: found character that cannot start any token",
+		yamlError("test.yml", fmt.Errorf("yaml: line 3: found character that cannot start any token")).Error())
+	assert.Equal(t, "test.yml: yaml: unknown",
+		yamlError("test.yml", fmt.Errorf("yaml: unknown")).Error())
+	assert.Equal(t, Problems{
+		{Position: "test.yml:2", Message: "yaml: field foo not found"},
+		{Position: "test.yml:4", Message: "yaml: cannot unmarshal"},
+	}, yamlError("test.yml", &yaml.TypeError{Errors: []string{
+		"line 2: field foo not found",
+		"line 4: cannot unmarshal",
+	}}))
+}
+
+func TestLocate(t *testing.T) {
+	data := []byte(`---
+variables:
+  lb: [192.0.2.1]
+templates:
+  - template: a
+    names:
+      - name: foo
+        addresses:
+          literals: [$lb]
+zones:
+  - zone: example.com.
+    templates:
+      - a
+    names:
+      - name: bar
+        texts:
+          data: [foo]
+        mail:
+          mailservers:
+            - mailserver: mx.example.com.
+`)
+	yamlFileData, err := parseFile("test.yml", data)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	pos := func(line, column int) position {
+		return position{file: "test.yml", line: line, column: column}
+	}
+	assert.Equal(t, map[string]position{"lb": pos(3, 3)},
+		yamlFileData.variablePositions)
+	template := yamlFileData.Templates[0]
+	assert.Equal(t, pos(5, 5), template.pos)
+	assert.Equal(t, pos(7, 9), template.Names[0].pos)
+	assert.Equal(t, map[string]position{"addresses": pos(9, 11)},
+		template.Names[0].sections)
+	zone := yamlFileData.Zones[0]
+	assert.Equal(t, pos(11, 5), zone.pos)
+	assert.Equal(t, pos(13, 9), zone.Templates[0].pos)
+	assert.Equal(t, pos(15, 9), zone.Names[0].pos)
+	assert.Equal(t, map[string]position{
+		"texts": pos(17, 11),
+		"mail":  pos(19, 11),
+	}, zone.Names[0].sections)
+
+	// empty documents have no positions
+	yamlFileData, err = parseFile("test.yml", []byte(""))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, yamlFile{variablePositions: map[string]position{}},
+		yamlFileData)
+}
+
+func TestLocateIgnored(t *testing.T) {
+	data := []byte(`---
+zones:
+  - zone: example.com.
+    names:
+      # dbcheck:ignore unmanaged
+      - name: www # dbcheck:ignore ttl-low
+        mail: # dbcheck:ignore mx-spf, mx-dmarc
+          mailservers:
+            # dbcheck:ignore mx-cname
+            - mailserver: mx.example.com.
+        addresses:
+          literals: [10.0.0.1] # dbcheck:ignore private-address
+      - name: foo # just a comment
+        texts:
+          data: [foo]
+`)
+	yamlFileData, err := parseFile("test.yml", data)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	names := yamlFileData.Zones[0].Names
+	assert.Equal(t, map[string][]string{
+		"":          {"unmanaged", "ttl-low"},
+		"mail":      {"mx-spf", "mx-dmarc", "mx-cname"},
+		"addresses": {"private-address"},
+	}, names[0].ignored)
+	assert.Equal(t, map[string][]string{}, names[1].ignored)
+
+	origin := names[0].describe(yamlFileData.Zones[0], []string{"MX", "A"})
+	assert.Equal(t, map[string][]string{
+		"MX": {"unmanaged", "ttl-low", "mx-spf", "mx-dmarc", "mx-cname"},
+		"A":  {"unmanaged", "ttl-low", "private-address"},
+	}, origin.Ignored)
+}
+
+func TestLocateAliases(t *testing.T) {
+	data := []byte(`---
+templates:
+  - template: base
+    names: &names
+      - name: www
+        addresses:
+          literals: [192.0.2.1]
+zones:
+  - zone: example.com.
+    names: *names
+  - &zone
+    zone: example.org.
+    names:
+      - &mail
+        name: '@'
+        mail:
+          mailservers:
+            - mailserver: mx.example.org.
+      - <<: *mail
+        name: shop
+        texts:
+          data: [foo]
+  - <<: *zone
+    zone: example.net.
+`)
+	yamlFileData, err := parseFile("test.yml", data)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	pos := func(line, column int) position {
+		return position{file: "test.yml", line: line, column: column}
+	}
+	// data of aliases is located at the anchored nodes
+	zones := yamlFileData.Zones
+	assert.Equal(t, 3, len(zones))
+	assert.Equal(t, pos(9, 5), zones[0].pos)
+	assert.Equal(t, "www", zones[0].Names[0].Name)
+	assert.Equal(t, pos(5, 9), zones[0].Names[0].pos)
+	assert.Equal(t, map[string]position{"addresses": pos(7, 11)},
+		zones[0].Names[0].sections)
+	// merge keys
+	for _, zone := range zones[1:] {
+		assert.Equal(t, 2, len(zone.Names))
+		assert.Equal(t, pos(14, 9), zone.Names[0].pos)
+		assert.Equal(t, "shop", zone.Names[1].Name)
+		assert.Equal(t, pos(19, 9), zone.Names[1].pos)
+		assert.Equal(t, map[string]position{
+			"mail":  pos(17, 11),
+			"texts": pos(22, 11),
+		}, zone.Names[1].sections)
+	}
+	assert.Equal(t, pos(23, 5), zones[2].pos)
+	assert.Equal(t, "example.net.", zones[2].Zone)
+
+	// unknown keys
+	_, err = parseFile("test.yml", []byte("zones:\n  - zone: example.com.\n    nmes: []\n"))
+	assert.Equal(t, "test.yml:3: yaml: field nmes not found in type rrdb.yamlZone",
+		fmt.Sprint(err))
+}
@@ -0,0 +1,136 @@
+package rrdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity tells whether a problem prevents zonedata from being loaded
+type Severity int
+
+const (
+	// SeverityError marks problems that prevent zonedata from being loaded
+	SeverityError Severity = iota
+	// SeverityWarning marks problems that do not prevent zonedata from being
+	// loaded but are likely mistakes
+	SeverityWarning
+)
+
+// String returns the name of a severity
+func (severity Severity) String() string {
+	if severity == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Problem is a single problem found while loading zonedata
+type Problem struct {
+	Position string // file:line:col, file or empty if unknown
+	Severity Severity
+	View     string // view the problem was found in, empty for all views
+	Message  string
+}
+
+// Error formats a problem like the errors of the loading functions, that is
+// prefixed with its position
+func (problem *Problem) Error() string {
+	msg := problem.Message
+	if problem.View != "" {
+		msg = fmt.Sprintf("view %v: %v", problem.View, msg)
+	}
+	if problem.Position == "" {
+		return msg
+	}
+	return fmt.Sprintf("%v: %v", problem.Position, msg)
+}
+
+// errorf creates an error at a position
+func errorf(pos position, format string, args ...interface{}) error {
+	return &Problem{
+		Position: pos.String(),
+		Severity: SeverityError,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// Problems is a multi-error holding all problems found while loading zonedata
+type Problems []*Problem
+
+// Error lists all problems, one per line
+func (problems Problems) Error() string {
+	msgs := []string{}
+	for _, problem := range problems {
+		msgs = append(msgs, problem.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// HasErrors checks if any of the problems is an error rather than a warning
+func (problems Problems) HasErrors() bool {
+	for _, problem := range problems {
+		if problem.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// collector collects the problems found while loading zonedata. A problem
+// found in several views is only recorded once, for the first view.
+type collector struct {
+	problems Problems
+	seen     map[string]bool
+	view     string // view that is currently loaded
+}
+
+func newCollector() *collector {
+	return &collector{
+		seen: make(map[string]bool),
+	}
+}
+
+// add records an error. Errors that are no problems have no position.
+func (c *collector) add(err error) {
+	switch e := err.(type) {
+	case *Problem:
+		c.record(*e)
+	case Problems:
+		for _, problem := range e {
+			c.record(*problem)
+		}
+	default:
+		c.record(Problem{Severity: SeverityError, Message: err.Error()})
+	}
+}
+
+// warnf records a warning at a position
+func (c *collector) warnf(pos position, format string, args ...interface{}) {
+	c.record(Problem{
+		Position: pos.String(),
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *collector) record(problem Problem) {
+	key := problem.Position + "\x00" + problem.Message
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+	if problem.View == "" {
+		problem.View = c.view
+	}
+	c.problems = append(c.problems, &problem)
+}
+
+// err returns the first error recorded, if any
+func (c *collector) err() error {
+	for _, problem := range c.problems {
+		if problem.Severity == SeverityError {
+			return problem
+		}
+	}
+	return nil
+}
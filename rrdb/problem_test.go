@@ -0,0 +1,103 @@
+package rrdb
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblemError(t *testing.T) {
+	assert.Equal(t, "test.yml:3:5: template a: empty", (&Problem{
+		Position: "test.yml:3:5",
+		Message:  "template a: empty",
+	}).Error())
+	assert.Equal(t, "test.yml:3:5: view internal: template a: empty", (&Problem{
+		Position: "test.yml:3:5",
+		View:     "internal",
+		Message:  "template a: empty",
+	}).Error())
+	assert.Equal(t, "empty database", (&Problem{
+		Message: "empty database",
+	}).Error())
+}
+
+func TestProblems(t *testing.T) {
+	problems := Problems{
+		{Position: "a.yml:3:5", Severity: SeverityWarning, Message: "template a: unused"},
+		{Position: "b.yml:7:9", Severity: SeverityError, Message: "zone example.com.: template b: not found"},
+	}
+	assert.Equal(t, true, problems.HasErrors())
+	assert.Equal(t, false, problems[:1].HasErrors())
+	assert.Equal(t, "a.yml:3:5: template a: unused\n"+
+		"b.yml:7:9: zone example.com.: template b: not found", problems.Error())
+	assert.Equal(t, "warning", SeverityWarning.String())
+	assert.Equal(t, "error", SeverityError.String())
+}
+
+func TestCollector(t *testing.T) {
+	problems := newCollector()
+	assert.Equal(t, nil, problems.err())
+	problems.warnf(position{file: "a.yml", line: 3, column: 5}, "template %v: unused", "a")
+	assert.Equal(t, nil, problems.err())
+	// problems are recorded once, for the first view they are found in
+	problems.view = "internal"
+	problems.add(errorf(position{file: "b.yml"}, "template %v: empty", "b"))
+	problems.view = "external"
+	problems.add(errorf(position{file: "b.yml"}, "template %v: empty", "b"))
+	problems.add(Problems{{Position: "c.yml:2", Message: "yaml: field foo not found"}})
+	assert.Equal(t, Problems{
+		{Position: "a.yml:3:5", Severity: SeverityWarning, Message: "template a: unused"},
+		{Position: "b.yml", View: "internal", Message: "template b: empty"},
+		{Position: "c.yml:2", View: "external", Message: "yaml: field foo not found"},
+	}, problems.problems)
+	assert.Equal(t, problems.problems[1], problems.err())
+}
+
+func TestCheckDirectories(t *testing.T) {
+	// warnings only
+	{
+		directory := path.Join("testdata", "pass", "variables")
+		dbs, problems := CheckDirectories([]string{directory})
+		assert.Equal(t, Problems{{
+			Position: path.Join(directory, "pools.yml") + ":12:3",
+			Severity: SeverityWarning,
+			Message:  "variable unused: unused",
+		}}, problems)
+		assert.Equal(t, false, problems.HasErrors())
+		assert.Equal(t, 1, len(dbs[directory]))
+	}
+	// all problems of all files
+	{
+		directory := path.Join("testdata", "fail", "multiple-errors")
+		a := path.Join(directory, "a.yml")
+		b := path.Join(directory, "b.yml")
+		dbs, problems := CheckDirectories([]string{directory})
+		assert.Equal(t, []string{
+			a + ":20:9: zone example.com.: template mail: not found",
+			a + ":29:11: zone example.com.: name api: variable lb: undefined",
+			a + ":7:11: zone example.com. (" + a + ":17:5): template web (" + a +
+				":3:5): name www: load addresses: invalid address: 192.0.2.256",
+			a + ":24:11: zone example.com.: name @: load addresses: invalid TTL: -300",
+			b + ":7:11: zone example.com.: name blog: load forwarding: conflicting records (previously defined at " +
+				a + ":31:9)",
+			a + ":9:5: template unused: unused",
+		}, errorStrings(problems))
+		assert.Equal(t, true, problems.HasErrors())
+		// everything else is loaded
+		db, err := dbs[directory].View("")
+		assert.Equal(t, nil, err)
+		if err == nil {
+			_, err = db.A("docs.example.com.", 300)
+			assert.Equal(t, nil, err)
+		}
+	}
+}
+
+func errorStrings(problems Problems) []string {
+	msgs := []string{}
+	for _, problem := range problems {
+		msgs = append(msgs, problem.Error())
+	}
+	return msgs
+}
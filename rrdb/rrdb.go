@@ -2,38 +2,31 @@ package rrdb
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/egymgmbh/dns-tools/lib"
 )
 
-// RRDB holds a resource record database
+// RRDB holds a resource record database. It is safe for concurrent use by
+// multiple readers and writers. Records retrieved are copies and can be
+// modified freely.
 type RRDB struct {
+	mu   sync.RWMutex // guards the whole trie
 	root node
-	// wrapped in a struct to leave room for future features that require fields
 }
 
 // This is a trie node. Tries are beautiful!
 // https://en.wikipedia.org/wiki/Trie
 type node struct {
-	fqdn       string
-	children   map[string]*node
-	parent     *node
-	nsRDatas   []string
-	nsTTL      int
-	mxRDatas   []string
-	mxTTL      int
-	txtRDatas  []string
-	txtTTL     int
-	txtSPF1    bool
-	txtDKIM1   bool
-	cnameRdata string
-	cnameTTL   int
-	aRDatas    []string
-	aTTL       int
-	aaaaRDatas []string
-	aaaaTTL    int
+	fqdn     string
+	children map[string]*node
+	parent   *node
+	rrsets   map[string]*rrset // by record type, see rtypes
+	cut      bool              // delegated to another managed zone
+	cutTTL   int
 }
 
 // Record holds a DNS resource record of a particular type for a FQDN
@@ -44,6 +37,17 @@ type Record struct {
 	RDatas []string
 }
 
+// SOA holds the fields of a start of authority record
+type SOA struct {
+	MName   string // primary nameserver
+	RName   string // mailbox of the responsible person
+	Serial  uint32
+	Refresh int
+	Retry   int
+	Expire  int
+	Minimum int // negative caching TTL
+}
+
 // Mailserver holds the preference and hostname of a single mailserver's entry
 type Mailserver struct {
 	Preference uint16
@@ -56,98 +60,349 @@ func New() *RRDB {
 		root: node{
 			children: make(map[string]*node),
 			parent:   nil,
+			rrsets:   make(map[string]*rrset),
 		},
 	}
 }
 
-// Records retrieves all records of a FQDN
+// Records retrieves all records of a FQDN in canonical order, see SortRecords
 func (db *RRDB) Records(fqdn string, ttl int) ([]*Record, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	nd, err := db.node(fqdn, false)
 	if err != nil {
 		return nil, err
 	}
-	return nd.records(ttl, false), nil
+	records := nd.records(ttl, false)
+	SortRecords(records)
+	return records, nil
 }
 
-// Zone retrieves all records of a FQDN and all records of all its children
+// Zone retrieves all records of a FQDN and all records of all its children in
+// canonical order, see SortRecords.
+// The records below delegations to other managed zones are left out, the
+// delegations themselves are returned as NS records without data. See
+// SetManagedDelegation.
 func (db *RRDB) Zone(fqdn string, ttl int) ([]*Record, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	nd, err := db.node(fqdn, false)
 	if err != nil {
 		return nil, err
 	}
-	return nd.records(ttl, true), nil
+	records := nd.zone(ttl, true)
+	SortRecords(records)
+	return records, nil
+}
+
+func (nd *node) zone(ttl int, apex bool) []*Record {
+	// everything below a zone cut belongs to the other managed zone
+	if nd.hasCut() && !apex {
+		record, _ := nd.managedDelegation(ttl)
+		return []*Record{record}
+	}
+	records := nd.records(ttl, false)
+	for _, next := range nd.children {
+		records = append(records, next.zone(ttl, false)...)
+	}
+	return records
+}
+
+// SortRecords sorts records in canonical order: by name in canonical DNS name
+// order (RFC 4034 section 6.1), then by record type number. The data of each
+// record is sorted as well, see SortRDatas.
+func SortRecords(records []*Record) {
+	for _, record := range records {
+		SortRDatas(record.RType, record.RDatas)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if c := lib.CompareNames(records[i].FQDN, records[j].FQDN); c != 0 {
+			return c < 0
+		}
+		return lib.CompareRTypes(records[i].RType, records[j].RType) < 0
+	})
 }
 
 func (nd *node) records(ttl int, withChildren bool) []*Record {
 	records := []*Record{}
+	for _, rt := range rtypes {
+		record, err := nd.rrset(ttl, rt.name)
+		if err == nil {
+			records = append(records, record)
+		}
+	}
 
-	record, err := nd.ns(ttl)
-	if err == nil {
-		records = append(records, record)
+	if withChildren {
+		for _, next := range nd.children {
+			records = append(records, next.records(ttl, true)...)
+		}
 	}
+	return records
+}
 
-	record, err = nd.mx(ttl)
-	if err == nil {
-		records = append(records, record)
+// Unmanaged returns the FQDNs of all names holding records that are not part
+// of any of the zones with the given apexes, in lexical order. A zone covers
+// the whole subtree of the trie below its apex.
+func (db *RRDB) Unmanaged(apexes []string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	managed := make(map[string]bool)
+	for _, apex := range apexes {
+		managed[apex] = true
 	}
+	fqdns := db.root.unmanaged(managed)
+	sort.Strings(fqdns)
+	return fqdns
+}
 
-	record, err = nd.txt(ttl)
-	if err == nil {
-		records = append(records, record)
+func (nd *node) unmanaged(managed map[string]bool) []string {
+	if managed[nd.fqdn] {
+		return nil
+	}
+	fqdns := []string{}
+	if nd.hasRecords() {
+		fqdns = append(fqdns, nd.fqdn)
+	}
+	for _, next := range nd.children {
+		fqdns = append(fqdns, next.unmanaged(managed)...)
 	}
+	return fqdns
+}
+
+/* --- deletion and replacement --------------------------------------------- */
 
-	record, err = nd.cname(ttl)
-	if err == nil {
-		records = append(records, record)
+// Delete removes the records of a type from a FQDN. Deleting NS records
+// removes delegations to other managed zones as well. The SOA record of an
+// apex with NS records can only be deleted if the apex has no other data.
+// Nodes left empty are removed from the trie.
+func (db *RRDB) Delete(fqdn, rtype string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	nd, err := db.node(fqdn, false)
+	if err != nil {
+		return err
 	}
+	saved := nd.rrsets[rtype]
+	err = nd.clear(rtype)
+	if err != nil {
+		return err
+	}
+	// without its SOA record, the NS records of an apex would delegate a FQDN
+	// that has other records or children
+	if rtype == "SOA" && nd.hasNS() && (len(nd.rrsets) > 1 || nd.hasChildren()) {
+		nd.rrsets[rtype] = saved
+		return fmt.Errorf("conflicting records")
+	}
+	nd.prune()
+	return nil
+}
 
-	record, err = nd.a(ttl)
-	if err == nil {
-		records = append(records, record)
+// DeleteSubtree removes a FQDN along with all its records and children
+func (db *RRDB) DeleteSubtree(fqdn string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	nd, err := db.node(fqdn, false)
+	if err != nil {
+		return err
 	}
+	delete(nd.parent.children, nd.label())
+	nd.parent.prune()
+	return nil
+}
 
-	record, err = nd.aaaa(ttl)
-	if err == nil {
-		records = append(records, record)
+// ReplaceSOA replaces the SOA record of a FQDN, or sets it if there is none
+func (db *RRDB) ReplaceSOA(fqdn string, ttl int, rdata string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.replace(fqdn, "SOA", func() error {
+		return db.set(fqdn, ttl, "SOA", []string{rdata})
+	})
+}
+
+// ReplaceNS replaces the NS records of a FQDN, or sets them if there are none
+func (db *RRDB) ReplaceNS(fqdn string, ttl int, rdatas []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.replace(fqdn, "NS", func() error {
+		return db.set(fqdn, ttl, "NS", rdatas)
+	})
+}
+
+// ReplaceMX replaces the MX records of a FQDN, or sets them if there are none
+func (db *RRDB) ReplaceMX(fqdn string, ttl int, rdatas []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.replace(fqdn, "MX", func() error {
+		return db.set(fqdn, ttl, "MX", rdatas)
+	})
+}
+
+// ReplaceTXT replaces all TXT records of a FQDN, or adds them if there are
+// none
+func (db *RRDB) ReplaceTXT(fqdn string, ttl int, rdatas []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.replace(fqdn, "TXT", func() error {
+		if len(rdatas) == 0 {
+			return fmt.Errorf("rdatas: empty")
+		}
+		for _, rdata := range rdatas {
+			err := db.add(fqdn, ttl, "TXT", rdata)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReplaceCNAME replaces the CNAME record of a FQDN, or sets it if there is none
+func (db *RRDB) ReplaceCNAME(fqdn string, ttl int, rdata string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.replace(fqdn, "CNAME", func() error {
+		return db.set(fqdn, ttl, "CNAME", []string{rdata})
+	})
+}
+
+// ReplaceA replaces the A records of a FQDN, or sets them if there are none
+func (db *RRDB) ReplaceA(fqdn string, ttl int, rdatas []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.replace(fqdn, "A", func() error {
+		return db.set(fqdn, ttl, "A", rdatas)
+	})
+}
+
+// ReplaceAAAA replaces the AAAA records of a FQDN, or sets them if there are
+// none
+func (db *RRDB) ReplaceAAAA(fqdn string, ttl int, rdatas []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.replace(fqdn, "AAAA", func() error {
+		return db.set(fqdn, ttl, "AAAA", rdatas)
+	})
+}
+
+// replace removes the records of a type from a FQDN and sets new ones, running
+// all checks of the set function again. The old records are restored if the
+// set function fails.
+func (db *RRDB) replace(fqdn, rtype string, set func() error) error {
+	nd, err := db.node(fqdn, false)
+	if err != nil {
+		// nothing to replace
+		err = set()
+		if err != nil {
+			db.prune(fqdn)
+		}
+		return err
+	}
+	saved, cut, cutTTL := nd.rrsets[rtype], nd.cut, nd.cutTTL
+	_ = nd.clear(rtype)
+	err = set()
+	if err != nil {
+		delete(nd.rrsets, rtype)
+		if saved != nil {
+			nd.rrsets[rtype] = saved
+		}
+		nd.cut, nd.cutTTL = cut, cutTTL
+		return err
 	}
+	return nil
+}
 
-	if withChildren {
-		for _, next := range nd.children {
-			records = append(records, next.records(ttl, true)...)
+// prune removes the empty nodes a failed set function may have left behind
+func (db *RRDB) prune(fqdn string) {
+	ls := strings.Split(strings.Trim(fqdn, "."), ".")
+	nd := &db.root
+	for idx := len(ls) - 1; idx >= 0; idx-- {
+		next, ok := nd.children[ls[idx]]
+		if !ok {
+			break
 		}
+		nd = next
+	}
+	nd.prune()
+}
+
+// clear removes the records of a type from a node
+func (nd *node) clear(name string) error {
+	_, err := lookupRType(name)
+	if err != nil {
+		return err
+	}
+	if name == "NS" && nd.hasCut() {
+		nd.cut, nd.cutTTL = false, 0
+		return nil
+	}
+	if !nd.has(name) {
+		return fmt.Errorf("FQDN has no %v record", name)
+	}
+	delete(nd.rrsets, name)
+	return nil
+}
+
+// prune removes a node from the trie if it has neither records nor children,
+// and its parents as long as they end up empty as well
+func (nd *node) prune() {
+	for nd.parent != nil && !nd.hasRecords() && !nd.hasChildren() {
+		delete(nd.parent.children, nd.label())
+		nd = nd.parent
 	}
-	return records
+}
+
+// label returns the leftmost label of a node's FQDN
+func (nd *node) label() string {
+	return strings.SplitN(nd.fqdn, ".", 2)[0]
 }
 
 /* --- helper functions ----------------------------------------------------- */
 
 func (nd *node) hasRecords() bool {
-	return nd.hasNS() || nd.hasMX() || nd.hasTXT() ||
-		nd.hasCNAME() || nd.hasA() || nd.hasAAAA()
+	return len(nd.rrsets) != 0 || nd.hasCut()
+}
+
+func (nd *node) has(name string) bool {
+	_, ok := nd.rrsets[name]
+	return ok
+}
+
+func (nd *node) hasCut() bool {
+	return nd.cut
+}
+
+func (nd *node) hasSOA() bool {
+	return nd.has("SOA")
 }
 
 func (nd *node) hasNS() bool {
-	return len(nd.nsRDatas) != 0
+	return nd.has("NS")
+}
+
+// hasDelegation checks if a node's NS records delegate it, i.e. if they are not
+// the NS records of a zone's apex
+func (nd *node) hasDelegation() bool {
+	return nd.hasNS() && !nd.hasSOA()
 }
 
 func (nd *node) hasMX() bool {
-	return len(nd.mxRDatas) != 0
+	return nd.has("MX")
 }
 
 func (nd *node) hasTXT() bool {
-	return len(nd.txtRDatas) != 0
+	return nd.has("TXT")
 }
 
 func (nd *node) hasCNAME() bool {
-	return len(nd.cnameRdata) != 0
+	return nd.has("CNAME")
 }
 
 func (nd *node) hasA() bool {
-	return len(nd.aRDatas) != 0
+	return nd.has("A")
 }
 
 func (nd *node) hasAAAA() bool {
-	return len(nd.aaaaRDatas) != 0
+	return nd.has("AAAA")
 }
 
 func (nd *node) hasChildren() bool {
@@ -164,8 +419,8 @@ func (nd *node) node(ls []string, idx int, create bool) (*node, error) {
 	if idx < 0 {
 		return nd, nil
 	}
-	// we can not go deeper if we have a NS record. Our authority ends there :/
-	if nd.hasNS() {
+	// we can not go deeper if we have a delegation. Our authority ends there :/
+	if nd.hasDelegation() {
 		return nil, fmt.Errorf("FQDN outside authority")
 	}
 	// now we can go deeper
@@ -178,6 +433,7 @@ func (nd *node) node(ls []string, idx int, create bool) (*node, error) {
 				fqdn:     strings.Join(ls[idx:], ".") + ".",
 				children: make(map[string]*node),
 				parent:   nd,
+				rrsets:   make(map[string]*rrset),
 			}
 		} else {
 			return nil, fmt.Errorf("FQDN not found")
@@ -196,84 +452,89 @@ func (db *RRDB) node(fqdn string, create bool) (*node, error) {
 	return db.root.node(ls, len(ls)-1, create)
 }
 
-/* --- NS ------------------------------------------------------------------- */
+/* --- SOA ------------------------------------------------------------------ */
 
-// SetNS sets the NS records of a FQDN
-func (db *RRDB) SetNS(fqdn string, ttl int, rdatas []string) error {
-	nd, err := db.node(fqdn, true)
-	if err != nil {
-		return err
+// ParseSOA parses the data of a SOA record in presentation format, e.g.
+// "ns1.example.com. hostmaster.example.com. 1 21600 3600 259200 300"
+func ParseSOA(rdata string) (*SOA, error) {
+	fields := strings.Fields(rdata)
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("invalid SOA: %v", rdata)
 	}
-	err = lib.IsValidTTL(ttl)
-	if err != nil {
-		return err
+	soa := &SOA{
+		MName: fields[0],
+		RName: fields[1],
 	}
-	// check empty
-	if len(rdatas) == 0 {
-		return fmt.Errorf("rdatas: empty")
+	err := lib.IsValidFQDN(soa.MName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOA mname: %v", err)
 	}
-	if nd.hasNS() {
-		return fmt.Errorf("NS record already set")
+	// the local part of the mailbox may contain escaped dots
+	err = lib.IsValidFQDN(strings.Replace(soa.RName, `\.`, "-", -1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOA rname: %v", err)
 	}
-	// validation and duplicate detection
-	seen := make(map[string]bool)
-	for _, rdata := range rdatas {
-		err = lib.IsValidFQDN(rdata)
-		if err != nil {
-			return fmt.Errorf("rdata: %v", err)
+	serial, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOA serial: %v", fields[2])
+	}
+	soa.Serial = uint32(serial)
+	values := []*int{&soa.Refresh, &soa.Retry, &soa.Expire, &soa.Minimum}
+	for idx, value := range values {
+		*value, err = strconv.Atoi(fields[3+idx])
+		if err == nil {
+			err = lib.IsValidTTL(*value)
 		}
-		if _, ok := seen[rdata]; ok {
-			return fmt.Errorf("rdata: duplicate entry: %v", rdata)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOA timer: %v", fields[3+idx])
 		}
-		seen[rdata] = true
 	}
+	return soa, nil
+}
 
-	/* --- BEGIN: logic checks ---------------------------------------------- */
-	// A FQDN can not have any other records it holds a delegation
-	if nd.hasRecords() {
-		return fmt.Errorf("conflicting records")
-	}
-	// A FQDN can not have sub-labels (children) when it holds a delegation
-	if nd.hasChildren() {
-		return fmt.Errorf("cannot delegate FQDN with children")
-	}
-	/* --- END: logic checks ------------------------------------------------ */
+// String formats a SOA record's data in presentation format
+func (soa *SOA) String() string {
+	return fmt.Sprintf("%v %v %d %d %d %d %d", soa.MName, soa.RName,
+		soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minimum)
+}
 
-	// all good
-	nd.nsTTL = ttl
-	nd.nsRDatas = rdatas
-	return nil
+// SetSOA sets the SOA record of a FQDN
+func (db *RRDB) SetSOA(fqdn string, ttl int, rdata string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.set(fqdn, ttl, "SOA", []string{rdata})
 }
 
-// NS retrieves the NS record of a FQDN. If the record has no individual
+// SOA retrieves the SOA record of a FQDN. If the record has no individual
 // TTL, a default TTL (paramter ttl) will be inserted.
-func (db *RRDB) NS(fqdn string, ttl int) (*Record, error) {
-	nd, err := db.node(fqdn, false)
-	if err != nil {
-		return nil, err
-	}
-	return nd.ns(ttl)
+func (db *RRDB) SOA(fqdn string, ttl int) (*Record, error) {
+	return db.get(fqdn, ttl, "SOA")
 }
 
-func (nd *node) ns(ttl int) (*Record, error) {
-	if !nd.hasNS() {
-		return nil, fmt.Errorf("FQDN has no NS record")
-	}
-	if nd.nsTTL != 0 {
-		ttl = nd.nsTTL
-	}
-	return &Record{
-		FQDN:   nd.fqdn,
-		RType:  "NS",
-		TTL:    ttl,
-		RDatas: nd.nsRDatas,
-	}, nil
+/* --- NS ------------------------------------------------------------------- */
+
+// SetNS sets the NS records of a FQDN
+func (db *RRDB) SetNS(fqdn string, ttl int, rdatas []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.set(fqdn, ttl, "NS", rdatas)
 }
 
-/* --- MX ------------------------------------------------------------------- */
+// NS retrieves the NS record of a FQDN. If the record has no individual
+// TTL, a default TTL (paramter ttl) will be inserted.
+func (db *RRDB) NS(fqdn string, ttl int) (*Record, error) {
+	return db.get(fqdn, ttl, "NS")
+}
 
-// SetMX adds a MX record to a FQDN
-func (db *RRDB) SetMX(fqdn string, ttl int, rdatas []string) error {
+/* --- delegations to managed zones ----------------------------------------- */
+
+// SetManagedDelegation delegates a FQDN to another managed zone, i.e. sets a
+// zone cut. Unlike for delegations with SetNS, the FQDN may have children and
+// records: They are the data of the other managed zone. The nameservers of the
+// other managed zone are only known when pushing the zones.
+func (db *RRDB) SetManagedDelegation(fqdn string, ttl int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	nd, err := db.node(fqdn, true)
 	if err != nil {
 		return err
@@ -282,368 +543,122 @@ func (db *RRDB) SetMX(fqdn string, ttl int, rdatas []string) error {
 	if err != nil {
 		return err
 	}
-	// check empty
-	if len(rdatas) == 0 {
-		return fmt.Errorf("rdatas: empty")
-	}
-	if nd.hasMX() {
-		return fmt.Errorf("MX record already set")
-	}
-	// accept RFC7505 null MX and skip tests in that case
-	if !(len(rdatas) == 1 && rdatas[0] == "0 .") {
-		// validation and duplicate detection
-		seen := make(map[string]bool)
-		for _, rdata := range rdatas {
-			mxLine := strings.SplitN(rdata, " ", 2)
-			preference, err := strconv.ParseInt(mxLine[0], 10, 64)
-			if err != nil {
-				return fmt.Errorf("rdata: invalid preference: %v", mxLine[0])
-			}
-			hostname := mxLine[1]
-			// check preference
-			if preference < 0 || preference > 65535 {
-				return fmt.Errorf("rdata: invalid preference: %v", preference)
-			}
-			// check hostname
-			err = lib.IsValidFQDN(hostname)
-			if err != nil {
-				return fmt.Errorf("rdata: %v", err)
-			}
-			if _, ok := seen[hostname]; ok {
-				return fmt.Errorf("rdata: duplicate entry: %v", rdata)
-			}
-			seen[hostname] = true
-		}
+	if nd.hasCut() {
+		return fmt.Errorf("delegation already set")
 	}
 
 	/* --- BEGIN: logic checks ---------------------------------------------- */
-	// A FQDN can not have a MX record when it also has a NS or CNAME record
+	// A FQDN can only be delegated once and the apex of the other zone can not
+	// be an alias
 	if nd.hasNS() || nd.hasCNAME() {
-		return fmt.Errorf("conflicting record")
+		return fmt.Errorf("conflicting records")
 	}
 	/* --- END: logic checks ------------------------------------------------ */
 
 	// all good
-	nd.mxTTL = ttl
-	nd.mxRDatas = rdatas
+	nd.cutTTL = ttl
+	nd.cut = true
 	return nil
 }
 
-// MX retrieves the MX record of a FQDN. If the record has no individual
-// TTL, a default TTL (paramter ttl) will be inserted.
-func (db *RRDB) MX(fqdn string, ttl int) (*Record, error) {
+// ManagedDelegation retrieves the delegation of a FQDN to another managed zone
+// as NS record without data. If the record has no individual TTL, a default
+// TTL (paramter ttl) will be inserted.
+func (db *RRDB) ManagedDelegation(fqdn string, ttl int) (*Record, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	nd, err := db.node(fqdn, false)
 	if err != nil {
 		return nil, err
 	}
-	return nd.mx(ttl)
+	return nd.managedDelegation(ttl)
 }
 
-func (nd *node) mx(ttl int) (*Record, error) {
-	if !nd.hasMX() {
-		return nil, fmt.Errorf("FQDN has no MX record")
+func (nd *node) managedDelegation(ttl int) (*Record, error) {
+	if !nd.hasCut() {
+		return nil, fmt.Errorf("FQDN has no delegation to a managed zone")
 	}
-	if nd.mxTTL != 0 {
-		ttl = nd.mxTTL
+	if nd.cutTTL != 0 {
+		ttl = nd.cutTTL
 	}
 	return &Record{
-		FQDN:   nd.fqdn,
-		RType:  "MX",
-		TTL:    ttl,
-		RDatas: nd.mxRDatas,
+		FQDN:  nd.fqdn,
+		RType: "NS",
+		TTL:   ttl,
 	}, nil
 }
 
-/* --- TXT ------------------------------------------------------------------ */
+/* --- MX ------------------------------------------------------------------- */
 
-// AddTXT adds a TXT record to a FQDN
-func (db *RRDB) AddTXT(fqdn string, ttl int, rdata string) error {
-	nd, err := db.node(fqdn, true)
-	if err != nil {
-		return err
-	}
-	err = lib.IsValidTTL(ttl)
-	if err != nil {
-		return err
-	}
-	// do not allow to reset previously set TTL
-	if nd.txtTTL != 0 && ttl != nd.txtTTL {
-		return fmt.Errorf("TTL already set")
-	}
-	// check empty
-	if len(rdata) == 0 {
-		return fmt.Errorf("rdata: empty")
-	}
-	// check maxlength
-	if len(rdata) > 255 {
-		return fmt.Errorf("rdata: too large")
-	}
-	// duplicate detection
-	for _, txt := range nd.txtRDatas {
-		if rdata == txt {
-			return fmt.Errorf("rdata: duplicate entry: %v", rdata)
-		}
-	}
+// SetMX adds a MX record to a FQDN
+func (db *RRDB) SetMX(fqdn string, ttl int, rdatas []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.set(fqdn, ttl, "MX", rdatas)
+}
 
-	/* --- BEGIN: logic checks ---------------------------------------------- */
-	// A FQDN can not have a TXT record when it also has a NS or CNAME record
-	if nd.hasNS() || nd.hasCNAME() {
-		return fmt.Errorf("conflicting records")
-	}
+// MX retrieves the MX record of a FQDN. If the record has no individual
+// TTL, a default TTL (paramter ttl) will be inserted.
+func (db *RRDB) MX(fqdn string, ttl int) (*Record, error) {
+	return db.get(fqdn, ttl, "MX")
+}
 
-	// some sanity checks to prevent the most common mistakes
-	rdataLower := strings.ToLower(rdata)
-	if strings.HasPrefix(rdataLower, "v=spf1 ") ||
-		strings.HasPrefix(rdataLower, "v=spf1;") {
-		if nd.txtSPF1 {
-			return fmt.Errorf("rdata: SPF already set")
-		}
-		nd.txtSPF1 = true
-	}
-	if strings.HasPrefix(rdataLower, "v=dkim1 ") ||
-		strings.HasPrefix(rdataLower, "v=dkim1;") {
-		if nd.txtDKIM1 {
-			return fmt.Errorf("rdata: DKIM already set")
-		}
-		nd.txtDKIM1 = true
-	}
-	/* --- END: logic checks ------------------------------------------------ */
+/* --- TXT ------------------------------------------------------------------ */
 
-	// all good
-	nd.txtTTL = ttl
-	nd.txtRDatas = append(nd.txtRDatas, rdata)
-	return nil
+// AddTXT adds a TXT record to a FQDN
+func (db *RRDB) AddTXT(fqdn string, ttl int, rdata string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.add(fqdn, ttl, "TXT", rdata)
 }
 
 // TXT retrieves the TXT record of a FQDN. If the record has no individual
 // TTL, a default TTL (paramter ttl) will be inserted.
 func (db *RRDB) TXT(fqdn string, ttl int) (*Record, error) {
-	nd, err := db.node(fqdn, false)
-	if err != nil {
-		return nil, err
-	}
-	return nd.txt(ttl)
-}
-
-func (nd *node) txt(ttl int) (*Record, error) {
-	if !nd.hasTXT() {
-		return nil, fmt.Errorf("FQDN has no TXT record")
-	}
-	if nd.txtTTL != 0 {
-		ttl = nd.txtTTL
-	}
-	rdatas := []string{}
-	for _, rdata := range nd.txtRDatas {
-		rdatas = append(rdatas, fmt.Sprintf("%q", rdata))
-	}
-	return &Record{
-		FQDN:   nd.fqdn,
-		RType:  "TXT",
-		TTL:    ttl,
-		RDatas: rdatas,
-	}, nil
+	return db.get(fqdn, ttl, "TXT")
 }
 
 /* --- CNAME ---------------------------------------------------------------- */
 
 // SetCNAME sets the CNAME record of a FQDN
 func (db *RRDB) SetCNAME(fqdn string, ttl int, rdata string) error {
-	nd, err := db.node(fqdn, true)
-	if err != nil {
-		return err
-	}
-	err = lib.IsValidTTL(ttl)
-	if err != nil {
-		return err
-	}
-	if nd.hasCNAME() {
-		return fmt.Errorf("CNAME record already set")
-	}
-	// validation
-	err = lib.IsValidFQDN(rdata)
-	if err != nil {
-		return fmt.Errorf("rdata: %v", err)
-	}
-
-	/* --- BEGIN: logic checks ---------------------------------------------- */
-	/*
-	 * RFC1912: A CNAME record is not allowed to coexist with any other data.
-	 * RFC1034: If a CNAME RR is present at a node, no other data should be
-	 * present; this ensures that the data for a canonical name and its aliases
-	 * cannot be different.
-	 */
-	if nd.hasRecords() {
-		return fmt.Errorf("conflicting records")
-	}
-	/* --- END: logic checks ------------------------------------------------ */
-
-	// all good
-	nd.cnameTTL = ttl
-	nd.cnameRdata = rdata
-	return nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.set(fqdn, ttl, "CNAME", []string{rdata})
 }
 
 // CNAME retrieves the CNAME record of a FQDN. If the record has no individual
 // TTL, a default TTL (paramter ttl) will be inserted.
 func (db *RRDB) CNAME(fqdn string, ttl int) (*Record, error) {
-	nd, err := db.node(fqdn, false)
-	if err != nil {
-		return nil, err
-	}
-	return nd.cname(ttl)
-}
-
-func (nd *node) cname(ttl int) (*Record, error) {
-	if !nd.hasCNAME() {
-		return nil, fmt.Errorf("FQDN has no CNAME record")
-	}
-	if nd.cnameTTL != 0 {
-		ttl = nd.cnameTTL
-	}
-	return &Record{
-		FQDN:   nd.fqdn,
-		RType:  "CNAME",
-		TTL:    ttl,
-		RDatas: []string{nd.cnameRdata},
-	}, nil
+	return db.get(fqdn, ttl, "CNAME")
 }
 
 /* --- A -------------------------------------------------------------------- */
 
 // SetA adds an A record to a FQDN
 func (db *RRDB) SetA(fqdn string, ttl int, rdatas []string) error {
-	nd, err := db.node(fqdn, true)
-	if err != nil {
-		return err
-	}
-	err = lib.IsValidTTL(ttl)
-	if err != nil {
-		return err
-	}
-	// check empty
-	if len(rdatas) == 0 {
-		return fmt.Errorf("rdatas: empty")
-	}
-	if nd.hasA() {
-		return fmt.Errorf("A record already set")
-	}
-	// validation and duplicate detection
-	seen := make(map[string]bool)
-	for _, rdata := range rdatas {
-		err = lib.IsValidIPv4(rdata)
-		if err != nil {
-			return fmt.Errorf("rdata: %v", err)
-		}
-		if _, ok := seen[rdata]; ok {
-			return fmt.Errorf("rdata: duplicate entry: %v", rdata)
-		}
-		seen[rdata] = true
-	}
-
-	/* --- BEGIN: logic checks ---------------------------------------------- */
-	// A FQDN can not have an A record when it also has a NS or CNAME record
-	if nd.hasNS() || nd.hasCNAME() {
-		return fmt.Errorf("conflicting records")
-	}
-	/* --- END: logic checks ------------------------------------------------ */
-
-	// all good
-	nd.aTTL = ttl
-	nd.aRDatas = rdatas
-	return nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.set(fqdn, ttl, "A", rdatas)
 }
 
 // A retrieves the A record of a FQDN. If the record has no individual
 // TTL, a default TTL (paramter ttl) will be inserted.
 func (db *RRDB) A(fqdn string, ttl int) (*Record, error) {
-	nd, err := db.node(fqdn, false)
-	if err != nil {
-		return nil, err
-	}
-	return nd.a(ttl)
-}
-
-func (nd *node) a(ttl int) (*Record, error) {
-	if !nd.hasA() {
-		return nil, fmt.Errorf("FQDN has no A record")
-	}
-	if nd.aTTL != 0 {
-		ttl = nd.aTTL
-	}
-	return &Record{
-		FQDN:   nd.fqdn,
-		RType:  "A",
-		TTL:    ttl,
-		RDatas: nd.aRDatas,
-	}, nil
+	return db.get(fqdn, ttl, "A")
 }
 
 /* --- AAAA ----------------------------------------------------------------- */
 
 // SetAAAA adds an AAAA record to a FQDN
 func (db *RRDB) SetAAAA(fqdn string, ttl int, rdatas []string) error {
-	nd, err := db.node(fqdn, true)
-	if err != nil {
-		return err
-	}
-	err = lib.IsValidTTL(ttl)
-	if err != nil {
-		return err
-	}
-	// check empty
-	if len(rdatas) == 0 {
-		return fmt.Errorf("rdatas: empty")
-	}
-	if nd.hasAAAA() {
-		return fmt.Errorf("AAAA record already set")
-	}
-	// validation and duplicate detection
-	seen := make(map[string]bool)
-	for _, rdata := range rdatas {
-		err = lib.IsValidIPv6(rdata)
-		if err != nil {
-			return fmt.Errorf("rdata: %v", err)
-		}
-		if _, ok := seen[rdata]; ok {
-			return fmt.Errorf("rdata: duplicate entry: %v", rdata)
-		}
-		seen[rdata] = true
-	}
-
-	/* --- BEGIN: logic checks ---------------------------------------------- */
-	// A FQDN can not have an AAAA record when it also has a NS or CNAME record
-	if nd.hasNS() || nd.hasCNAME() {
-		return fmt.Errorf("conflicting records")
-	}
-	/* --- END: logic checks ------------------------------------------------ */
-
-	// all good
-	nd.aaaaTTL = ttl
-	nd.aaaaRDatas = rdatas
-	return nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.set(fqdn, ttl, "AAAA", rdatas)
 }
 
 // AAAA retrieves the AAAA record of a FQDN. If the record has no individual
 // TTL, a default TTL (paramter ttl) will be inserted.
 func (db *RRDB) AAAA(fqdn string, ttl int) (*Record, error) {
-	nd, err := db.node(fqdn, false)
-	if err != nil {
-		return nil, err
-	}
-	return nd.aaaa(ttl)
-}
-
-func (nd *node) aaaa(ttl int) (*Record, error) {
-	if !nd.hasAAAA() {
-		return nil, fmt.Errorf("FQDN has no AAAA record")
-	}
-	if nd.aaaaTTL != 0 {
-		ttl = nd.aaaaTTL
-	}
-	return &Record{
-		FQDN:   nd.fqdn,
-		RType:  "AAAA",
-		TTL:    ttl,
-		RDatas: nd.aaaaRDatas,
-	}, nil
+	return db.get(fqdn, ttl, "AAAA")
 }
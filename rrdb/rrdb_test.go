@@ -1,7 +1,9 @@
 package rrdb
 
 import (
+	"path"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -377,6 +379,57 @@ func TestDBRecords(t *testing.T) {
 	}
 }
 
+func TestSortRecords(t *testing.T) {
+	records := []*Record{
+		{FQDN: "b.example.", RType: "A", RDatas: []string{"192.0.2.10", "192.0.2.9"}},
+		{FQDN: "example.", RType: "SOA"},
+		{FQDN: "a.b.example.", RType: "TXT"},
+		{FQDN: "example.", RType: "NS"},
+		{FQDN: "B.example.", RType: "AAAA"},
+		{FQDN: "a.example.", RType: "MX", RDatas: []string{"10 mx.example.", "9 mx.example."}},
+		{FQDN: "example.", RType: "A"},
+	}
+	SortRecords(records)
+	keys := []string{}
+	for _, record := range records {
+		keys = append(keys, record.FQDN+" "+record.RType)
+	}
+	assert.Equal(t, []string{
+		"example. A",
+		"example. NS",
+		"example. SOA",
+		"a.example. MX",
+		"b.example. A",
+		"B.example. AAAA",
+		"a.b.example. TXT",
+	}, keys)
+	assert.Equal(t, []string{"9 mx.example.", "10 mx.example."}, records[3].RDatas)
+	assert.Equal(t, []string{"192.0.2.9", "192.0.2.10"}, records[4].RDatas)
+
+	// zones are returned in canonical order
+	db := New()
+	assert.Equal(t, nil, db.SetA("z.example.", validTTL, validA))
+	assert.Equal(t, nil, db.SetA("b.a.example.", validTTL, validA))
+	assert.Equal(t, nil, db.SetMX("example.", validTTL, validMX))
+	assert.Equal(t, nil, db.SetA("example.", validTTL, validA))
+	assert.Equal(t, nil, db.SetA("a.example.", validTTL, validA))
+	for idx := 0; idx < 10; idx++ {
+		records, err := db.Zone("example.", validTTL)
+		assert.Equal(t, nil, err)
+		keys := []string{}
+		for _, record := range records {
+			keys = append(keys, record.FQDN+" "+record.RType)
+		}
+		assert.Equal(t, []string{
+			"example. A",
+			"example. MX",
+			"a.example. A",
+			"b.a.example. A",
+			"z.example. A",
+		}, keys)
+	}
+}
+
 func TestDBZone(t *testing.T) {
 	// empty
 	{
@@ -443,6 +496,27 @@ func TestDBZone(t *testing.T) {
 	}
 }
 
+func TestDBUnmanaged(t *testing.T) {
+	db := New()
+	_ = db.SetA("example.com.", validTTL, validA)
+	_ = db.SetA("www.example.com.", validTTL, validA)
+	_ = db.SetA("sub.example.com.", validTTL, validA)
+	_ = db.SetA("www.sub.example.com.", validTTL, validA)
+	_ = db.SetA("example.org.", validTTL, validA)
+	_ = db.SetA("www.example.org.", validTTL, validA)
+
+	assert.Equal(t, []string{"example.com.", "example.org.", "sub.example.com.",
+		"www.example.com.", "www.example.org.", "www.sub.example.com."},
+		db.Unmanaged(nil))
+	assert.Equal(t, []string{"example.org.", "www.example.org."},
+		db.Unmanaged([]string{"example.com."}))
+	assert.Equal(t, []string{"example.com.", "www.example.com."},
+		db.Unmanaged([]string{"sub.example.com.", "example.org."}))
+	assert.Equal(t, []string{"example.com.", "example.org.", "sub.example.com.",
+		"www.example.com.", "www.example.org."},
+		db.Unmanaged([]string{"www.sub.example.com.", "foo.example.net."}))
+}
+
 /* --- Node ----------------------------------------------------------------- */
 
 func TestNodeHasRType(t *testing.T) {
@@ -731,6 +805,83 @@ func TestDBNS(t *testing.T) {
 	}
 }
 
+/* --- delegations to managed zones ----------------------------------------- */
+
+func TestDBSetManagedDelegation(t *testing.T) {
+	// invalid TTL
+	{
+		db := New()
+		err := db.SetManagedDelegation("sub."+testFQDN, -1)
+		assert.NotEqual(t, nil, err)
+	}
+	// conflicts
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetNS("ns."+testFQDN, validTTL, validNS))
+		assert.NotEqual(t, nil, db.SetManagedDelegation("ns."+testFQDN, 0))
+		assert.Equal(t, nil, db.SetCNAME("cname."+testFQDN, validTTL, validCNAME))
+		assert.NotEqual(t, nil, db.SetManagedDelegation("cname."+testFQDN, 0))
+		assert.Equal(t, nil, db.SetManagedDelegation("sub."+testFQDN, 0))
+		assert.NotEqual(t, nil, db.SetManagedDelegation("sub."+testFQDN, 0))
+		assert.NotEqual(t, nil, db.SetNS("sub."+testFQDN, validTTL, validNS))
+		assert.NotEqual(t, nil, db.SetCNAME("sub."+testFQDN, validTTL, validCNAME))
+	}
+	// data of the other zone below and at the zone cut
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetA("www.sub."+testFQDN, validTTL, validA))
+		assert.Equal(t, nil, db.SetManagedDelegation("sub."+testFQDN, otherValidTTL))
+		assert.Equal(t, nil, db.SetMX("sub."+testFQDN, validTTL, validMX))
+		assert.Equal(t, nil, db.SetA("www."+testFQDN, validTTL, validA))
+
+		records, err := db.Zone(testFQDN, validTTL)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, helperCompareRecords([]*Record{
+			{
+				FQDN:  "sub." + testFQDN,
+				RType: "NS",
+				TTL:   otherValidTTL,
+			},
+			{
+				FQDN:   "www." + testFQDN,
+				RType:  "A",
+				TTL:    validTTL,
+				RDatas: validA,
+			},
+		}, records))
+		records, err = db.Zone("sub."+testFQDN, validTTL)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, helperCompareRecords([]*Record{
+			{
+				FQDN:   "sub." + testFQDN,
+				RType:  "MX",
+				TTL:    validTTL,
+				RDatas: validMX,
+			},
+			{
+				FQDN:   "www.sub." + testFQDN,
+				RType:  "A",
+				TTL:    validTTL,
+				RDatas: validA,
+			},
+		}, records))
+	}
+}
+
+func TestDBManagedDelegation(t *testing.T) {
+	db := New()
+	_, err := db.ManagedDelegation("sub."+testFQDN, validTTL)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, nil, db.SetManagedDelegation("sub."+testFQDN, 0))
+	record, err := db.ManagedDelegation("sub."+testFQDN, validTTL)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Record{FQDN: "sub." + testFQDN, RType: "NS", TTL: validTTL},
+		record)
+	// not a delegation with nameservers
+	_, err = db.NS("sub."+testFQDN, validTTL)
+	assert.NotEqual(t, nil, err)
+}
+
 /* --- MX ------------------------------------------------------------------- */
 
 func TestDBSetMXParameterTTL(t *testing.T) {
@@ -1388,3 +1539,337 @@ func TestDBAAAA(t *testing.T) {
 		}
 	}
 }
+
+/* --- SOA ------------------------------------------------------------------ */
+
+var (
+	validSOA    = "ns1.example.com. hostmaster.example.com. 1 21600 3600 259200 300"
+	invalidSOAs = []string{
+		"",
+		"ns1.example.com. hostmaster.example.com. 1 21600 3600 259200",
+		"ns1.example.com hostmaster.example.com. 1 21600 3600 259200 300",
+		"ns1.example.com. hostmaster.example.com 1 21600 3600 259200 300",
+		"ns1.example.com. hostmaster.example.com. -1 21600 3600 259200 300",
+		"ns1.example.com. hostmaster.example.com. 4294967296 21600 3600 259200 300",
+		"ns1.example.com. hostmaster.example.com. 1 foo 3600 259200 300",
+		"ns1.example.com. hostmaster.example.com. 1 21600 -3600 259200 300",
+	}
+)
+
+func TestParseSOA(t *testing.T) {
+	for _, rdata := range invalidSOAs {
+		soa, err := ParseSOA(rdata)
+		assert.NotEqual(t, nil, err, rdata)
+		assert.Equal(t, (*SOA)(nil), soa, rdata)
+	}
+	{
+		soa, err := ParseSOA(validSOA)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, &SOA{
+			MName:   "ns1.example.com.",
+			RName:   "hostmaster.example.com.",
+			Serial:  1,
+			Refresh: 21600,
+			Retry:   3600,
+			Expire:  259200,
+			Minimum: 300,
+		}, soa)
+		if err == nil {
+			assert.Equal(t, validSOA, soa.String())
+		}
+	}
+	// escaped dot in mailbox, extra whitespace
+	{
+		soa, err := ParseSOA("ns1.example.com.  host\\.master.example.com. 1 2 3 4 5")
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, "ns1.example.com. host\\.master.example.com. 1 2 3 4 5",
+				soa.String())
+		}
+	}
+}
+
+func TestDBSetSOA(t *testing.T) {
+	// invalid TTL
+	for _, ttl := range invalidTTLs {
+		db := New()
+		err := db.SetSOA(testFQDN, ttl, validSOA)
+		assert.NotEqual(t, nil, err)
+	}
+	// invalid rdata
+	for _, rdata := range invalidSOAs {
+		db := New()
+		err := db.SetSOA(testFQDN, validTTL, rdata)
+		assert.NotEqual(t, nil, err)
+	}
+	// try to overwrite
+	{
+		db := New()
+		err := db.SetSOA(testFQDN, validTTL, validSOA)
+		assert.Equal(t, nil, err)
+		err = db.SetSOA(testFQDN, validTTL, validSOA)
+		assert.NotEqual(t, nil, err)
+	}
+	// conflicting records
+	{
+		db := New()
+		err := db.SetCNAME(testFQDN, validTTL, validCNAME)
+		assert.Equal(t, nil, err)
+		err = db.SetSOA(testFQDN, validTTL, validSOA)
+		assert.NotEqual(t, nil, err)
+	}
+	{
+		db := New()
+		err := db.SetSOA(testFQDN, validTTL, validSOA)
+		assert.Equal(t, nil, err)
+		err = db.SetCNAME(testFQDN, validTTL, validCNAME)
+		assert.NotEqual(t, nil, err)
+		err = db.SetMX(testFQDN, validTTL, validMX)
+		assert.Equal(t, nil, err)
+	}
+}
+
+func TestDBApex(t *testing.T) {
+	// the SOA and NS records of an apex coexist, in either order
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetSOA(testFQDN, validTTL, validSOA))
+		assert.Equal(t, nil, db.SetNS(testFQDN, validTTL, validNS))
+		assert.Equal(t, nil, db.SetMX(testFQDN, validTTL, validMX))
+		assert.Equal(t, nil, db.SetA("www."+testFQDN, validTTL, validA))
+		records, err := db.Records(testFQDN, validTTL)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 3, len(records))
+		// an apex can not lose its SOA record while it has other data
+		assert.NotEqual(t, nil, db.Delete(testFQDN, "SOA"))
+		_, err = db.SOA(testFQDN, validTTL)
+		assert.Equal(t, nil, err)
+	}
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetNS(testFQDN, validTTL, validNS))
+		assert.Equal(t, nil, db.SetSOA(testFQDN, validTTL, validSOA))
+		assert.Equal(t, nil, db.SetMX(testFQDN, validTTL, validMX))
+		assert.Equal(t, nil, db.Delete(testFQDN, "MX"))
+		assert.Equal(t, nil, db.Delete(testFQDN, "SOA"))
+	}
+	// NS records without SOA record are a delegation
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetNS(testFQDN, validTTL, validNS))
+		assert.NotEqual(t, nil, db.SetMX(testFQDN, validTTL, validMX))
+		assert.NotEqual(t, nil, db.SetA("www."+testFQDN, validTTL, validA))
+	}
+}
+
+func TestDBSOA(t *testing.T) {
+	db := New()
+	_, err := db.SOA(testFQDN, validTTL)
+	assert.NotEqual(t, nil, err)
+	err = db.SetSOA(testFQDN, 0, validSOA)
+	assert.Equal(t, nil, err)
+	record, err := db.SOA(testFQDN, otherValidTTL)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Record{
+		FQDN:   testFQDN,
+		RType:  "SOA",
+		TTL:    otherValidTTL,
+		RDatas: []string{validSOA},
+	}, record)
+}
+
+/* --- deletion and replacement --------------------------------------------- */
+
+func TestDBDelete(t *testing.T) {
+	// nonexistent
+	{
+		db := New()
+		assert.NotEqual(t, nil, db.Delete(testFQDN, "A"))
+		assert.Equal(t, nil, db.SetAAAA(testFQDN, validTTL, validAAAA))
+		assert.NotEqual(t, nil, db.Delete(testFQDN, "A"))
+		assert.NotEqual(t, nil, db.Delete(testFQDN, "SRV"))
+	}
+	// every record type
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetSOA(testFQDN, validTTL, validSOA))
+		assert.Equal(t, nil, db.SetMX(testFQDN, validTTL, validMX))
+		assert.Equal(t, nil, db.AddTXT(testFQDN, validTTL, "v=spf1 -all"))
+		assert.Equal(t, nil, db.SetA(testFQDN, validTTL, validA))
+		assert.Equal(t, nil, db.SetAAAA(testFQDN, validTTL, validAAAA))
+		assert.Equal(t, nil, db.SetNS("ns."+testFQDN, validTTL, validNS))
+		assert.Equal(t, nil, db.SetCNAME("cname."+testFQDN, validTTL, validCNAME))
+		assert.Equal(t, nil, db.SetManagedDelegation("sub."+testFQDN, 0))
+		for _, rtype := range []string{"SOA", "MX", "TXT", "A", "AAAA"} {
+			assert.Equal(t, nil, db.Delete(testFQDN, rtype), rtype)
+		}
+		assert.Equal(t, nil, db.Delete("ns."+testFQDN, "NS"))
+		assert.Equal(t, nil, db.Delete("cname."+testFQDN, "CNAME"))
+		assert.Equal(t, nil, db.Delete("sub."+testFQDN, "NS"))
+		// all nodes are gone
+		assert.Equal(t, 0, len(db.root.children))
+		// deleted SPF records can be set again
+		assert.Equal(t, nil, db.AddTXT(testFQDN, validTTL, "v=spf1 mx -all"))
+	}
+	// empty parents are pruned, parents with records are kept
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetA("a.b."+testFQDN, validTTL, validA))
+		assert.Equal(t, nil, db.SetA(testFQDN, validTTL, validA))
+		assert.Equal(t, nil, db.Delete("a.b."+testFQDN, "A"))
+		nd, err := db.node(testFQDN, false)
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, false, nd.hasChildren())
+		}
+		// a delegation is possible now that the children are gone
+		assert.Equal(t, nil, db.Delete(testFQDN, "A"))
+		assert.Equal(t, nil, db.SetNS(testFQDN, validTTL, validNS))
+	}
+}
+
+func TestDBDeleteSubtree(t *testing.T) {
+	db := New()
+	assert.NotEqual(t, nil, db.DeleteSubtree(testFQDN))
+	assert.Equal(t, nil, db.SetA(testFQDN, validTTL, validA))
+	assert.Equal(t, nil, db.SetA("www."+testFQDN, validTTL, validA))
+	assert.Equal(t, nil, db.SetA("www.sub."+testFQDN, validTTL, validA))
+	assert.Equal(t, nil, db.SetA("sub2."+testFQDN, validTTL, validA))
+
+	assert.Equal(t, nil, db.DeleteSubtree("sub."+testFQDN))
+	records, err := db.Zone(testFQDN, validTTL)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, nil, db.DeleteSubtree(testFQDN))
+	_, err = db.Zone(testFQDN, validTTL)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 0, len(db.root.children))
+}
+
+func TestDBReplace(t *testing.T) {
+	// sets records if there are none
+	{
+		db := New()
+		assert.Equal(t, nil, db.ReplaceSOA(testFQDN, validTTL, validSOA))
+		assert.Equal(t, nil, db.ReplaceMX(testFQDN, validTTL, validMX))
+		assert.Equal(t, nil, db.ReplaceTXT(testFQDN, validTTL, validTXT.in))
+		assert.Equal(t, nil, db.ReplaceA(testFQDN, validTTL, validA))
+		assert.Equal(t, nil, db.ReplaceAAAA(testFQDN, validTTL, validAAAA))
+		assert.Equal(t, nil, db.ReplaceNS("ns."+testFQDN, validTTL, validNS))
+		assert.Equal(t, nil, db.ReplaceCNAME("cname."+testFQDN, validTTL,
+			validCNAME))
+		records, err := db.Zone(testFQDN, validTTL)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 7, len(records))
+	}
+	// replaces records
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetA(testFQDN, validTTL, validA))
+		assert.Equal(t, nil, db.ReplaceA(testFQDN, otherValidTTL,
+			[]string{"192.0.2.99"}))
+		record, err := db.A(testFQDN, 0)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, &Record{FQDN: testFQDN, RType: "A", TTL: otherValidTTL,
+			RDatas: []string{"192.0.2.99"}}, record)
+
+		assert.Equal(t, nil, db.AddTXT(testFQDN, validTTL, "v=spf1 -all"))
+		assert.Equal(t, nil, db.ReplaceTXT(testFQDN, validTTL,
+			[]string{"v=spf1 mx -all", "foo"}))
+		record, err = db.TXT(testFQDN, 0)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []string{`"v=spf1 mx -all"`, `"foo"`}, record.RDatas)
+	}
+	// conflict checks are run again and failures keep the old records
+	{
+		db := New()
+		assert.Equal(t, nil, db.SetA(testFQDN, validTTL, validA))
+		assert.Equal(t, nil, db.SetCNAME("cname."+testFQDN, validTTL, validCNAME))
+		assert.NotEqual(t, nil, db.ReplaceCNAME(testFQDN, validTTL, validCNAME))
+		assert.NotEqual(t, nil, db.ReplaceA(testFQDN, validTTL, invalidAs[1]))
+		assert.NotEqual(t, nil, db.ReplaceTXT(testFQDN, validTTL,
+			[]string{"v=spf1 -all", "v=spf1 mx -all"}))
+		record, err := db.A(testFQDN, 0)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, validA, record.RDatas)
+		_, err = db.TXT(testFQDN, 0)
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, nil, db.ReplaceCNAME("cname."+testFQDN, validTTL,
+			"www.example.com."))
+		record, err = db.CNAME("cname."+testFQDN, 0)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []string{"www.example.com."}, record.RDatas)
+	}
+	// failures do not leave empty nodes behind
+	{
+		db := New()
+		assert.NotEqual(t, nil, db.ReplaceA("www."+testFQDN, validTTL,
+			invalidAs[1]))
+		assert.Equal(t, 0, len(db.root.children))
+	}
+}
+
+func TestDBConcurrency(t *testing.T) {
+	directory := path.Join("testdata", "pass", "simple-zone")
+	db, err := NewFromDirectory(directory)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	zone, err := db.Zone("example.com.", validTTL)
+	assert.Equal(t, nil, err)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	// readers
+	for idx := 0; idx < 4; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				records, err := db.Zone("example.com.", validTTL)
+				assert.Equal(t, nil, err)
+				for _, record := range records {
+					// records are copies
+					record.RDatas = append(record.RDatas[:0], "modified")
+				}
+				_, err = db.Records("example.com.", validTTL)
+				assert.Equal(t, nil, err)
+				_, _ = db.A("dyn.example.com.", validTTL)
+				_, _ = db.TXT("dyn.example.com.", validTTL)
+				_, _ = db.CNAME("foo.example.com.", validTTL)
+				_ = db.Unmanaged([]string{"example.com."})
+			}
+		}()
+	}
+	// reloads build new instances while the old one is read
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for idx := 0; idx < 10; idx++ {
+			reloaded, err := NewFromDirectory(directory)
+			assert.Equal(t, nil, err)
+			_, err = reloaded.Zone("example.com.", validTTL)
+			assert.Equal(t, nil, err)
+		}
+	}()
+	// one writer
+	for idx := 0; idx < 100; idx++ {
+		assert.Equal(t, nil, db.ReplaceA("dyn.example.com.", validTTL,
+			[]string{"192.0.2.1"}))
+		assert.Equal(t, nil, db.AddTXT("dyn.example.com.", validTTL, "foo"))
+		assert.Equal(t, nil, db.Delete("dyn.example.com.", "TXT"))
+		assert.Equal(t, nil, db.DeleteSubtree("dyn.example.com."))
+	}
+	close(done)
+	wg.Wait()
+
+	records, err := db.Zone("example.com.", validTTL)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, zone, records)
+}
@@ -0,0 +1,369 @@
+package rrdb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/egymgmbh/dns-tools/lib"
+)
+
+// rrset holds the records of a single type of a FQDN
+type rrset struct {
+	ttl    int
+	rdatas []string
+}
+
+// rtype describes a record type: how its data is validated, which records it
+// can not coexist with and how it is presented
+type rtype struct {
+	name string
+	// single types hold exactly one record, e.g. CNAME
+	single bool
+	// validate checks the data of a whole RRset and returns it normalized
+	validate func(rdatas []string) ([]string, error)
+	// conflicts checks the other records and the children of a node
+	conflicts func(nd *node) error
+	// canonical converts data in presentation format to its canonical form,
+	// see CanonicalRData
+	canonical func(rdata string) string
+	// format converts data to presentation format, nil if data is stored in
+	// presentation format
+	format func(rdata string) string
+}
+
+// rtypes lists the supported record types
+var rtypes = []*rtype{
+	{
+		name:      "SOA",
+		single:    true,
+		validate:  validateSOA,
+		conflicts: conflictsSOA,
+		canonical: canonicalSOA,
+	},
+	{
+		name:      "NS",
+		validate:  validateEach(lib.IsValidFQDN, nil),
+		conflicts: conflictsNS,
+		canonical: canonicalName,
+	},
+	{
+		name:      "MX",
+		validate:  validateMX,
+		conflicts: coexisting("conflicting record"),
+		canonical: canonicalMX,
+	},
+	{
+		name:      "TXT",
+		validate:  validateTXT,
+		conflicts: coexisting("conflicting records"),
+		canonical: canonicalTXT,
+		format:    quoteTXT,
+	},
+	{
+		name:      "CNAME",
+		single:    true,
+		validate:  validateEach(lib.IsValidFQDN, nil),
+		conflicts: conflictsCNAME,
+		canonical: canonicalName,
+	},
+	{
+		name:      "A",
+		validate:  validateEach(lib.IsValidIPv4, nil),
+		conflicts: coexisting("conflicting records"),
+		canonical: canonicalIP,
+	},
+	{
+		name:      "AAAA",
+		validate:  validateEach(lib.IsValidIPv6, nil),
+		conflicts: coexisting("conflicting records"),
+		canonical: canonicalIP,
+	},
+}
+
+// lookupRType finds a record type in the registry
+func lookupRType(name string) (*rtype, error) {
+	for _, rt := range rtypes {
+		if rt.name == name {
+			return rt, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported record type: %v", name)
+}
+
+// store converts data to the form it is stored in: Data stored in presentation
+// format is stored in its canonical form, so equal records are detected as
+// duplicates.
+func (rt *rtype) store(rdatas []string) []string {
+	if rt.format != nil {
+		return rdatas
+	}
+	out := []string{}
+	for _, rdata := range rdatas {
+		out = append(out, rt.canonical(rdata))
+	}
+	return out
+}
+
+// set sets the RRset of a type of a FQDN, which must not exist yet. The caller
+// has to hold the write lock.
+func (db *RRDB) set(fqdn string, ttl int, name string, rdatas []string) error {
+	rt, err := lookupRType(name)
+	if err != nil {
+		return err
+	}
+	nd, err := db.node(fqdn, true)
+	if err != nil {
+		return err
+	}
+	err = lib.IsValidTTL(ttl)
+	if err != nil {
+		return err
+	}
+	// check empty
+	if !rt.single && len(rdatas) == 0 {
+		return fmt.Errorf("rdatas: empty")
+	}
+	if nd.has(name) {
+		return fmt.Errorf("%v record already set", name)
+	}
+	// validation and duplicate detection
+	rdatas, err = rt.validate(rt.store(rdatas))
+	if err != nil {
+		return err
+	}
+	// logic checks
+	err = rt.conflicts(nd)
+	if err != nil {
+		return err
+	}
+
+	// all good
+	nd.rrsets[name] = &rrset{ttl: ttl, rdatas: rdatas}
+	return nil
+}
+
+// add adds a record to the RRset of a type of a FQDN. All records of an RRset
+// share the same TTL. The caller has to hold the write lock.
+func (db *RRDB) add(fqdn string, ttl int, name string, rdata string) error {
+	rt, err := lookupRType(name)
+	if err != nil {
+		return err
+	}
+	nd, err := db.node(fqdn, true)
+	if err != nil {
+		return err
+	}
+	err = lib.IsValidTTL(ttl)
+	if err != nil {
+		return err
+	}
+	set, ok := nd.rrsets[name]
+	if !ok {
+		set = &rrset{}
+	}
+	// do not allow to reset previously set TTL
+	if set.ttl != 0 && ttl != set.ttl {
+		return fmt.Errorf("TTL already set")
+	}
+	// validation and duplicate detection, the new record is validated first
+	rdatas, err := rt.validate(append(rt.store([]string{rdata}), set.rdatas...))
+	if err != nil {
+		return err
+	}
+	// logic checks
+	err = rt.conflicts(nd)
+	if err != nil {
+		return err
+	}
+
+	// all good, keep the order the records were added in
+	nd.rrsets[name] = &rrset{ttl: ttl, rdatas: append(rdatas[1:], rdatas[0])}
+	return nil
+}
+
+// get retrieves the RRset of a type of a FQDN
+func (db *RRDB) get(fqdn string, ttl int, name string) (*Record, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	nd, err := db.node(fqdn, false)
+	if err != nil {
+		return nil, err
+	}
+	return nd.rrset(ttl, name)
+}
+
+// rrset retrieves the RRset of a type of a node. If the records have no
+// individual TTL, a default TTL (paramter ttl) will be inserted.
+func (nd *node) rrset(ttl int, name string) (*Record, error) {
+	rt, err := lookupRType(name)
+	if err != nil {
+		return nil, err
+	}
+	set, ok := nd.rrsets[name]
+	if !ok {
+		return nil, fmt.Errorf("FQDN has no %v record", name)
+	}
+	if set.ttl != 0 {
+		ttl = set.ttl
+	}
+	rdatas := append([]string(nil), set.rdatas...)
+	if rt.format != nil {
+		rdatas = []string{}
+		for _, rdata := range set.rdatas {
+			rdatas = append(rdatas, rt.format(rdata))
+		}
+	}
+	return &Record{
+		FQDN:   nd.fqdn,
+		RType:  name,
+		TTL:    ttl,
+		RDatas: rdatas,
+	}, nil
+}
+
+/* --- validators ----------------------------------------------------------- */
+
+// validateEach returns a validator checking every record with a function and
+// detecting duplicates. Records are duplicates if they have the same key, by
+// default the record itself.
+func validateEach(check func(rdata string) error,
+	key func(rdata string) string) func([]string) ([]string, error) {
+	return func(rdatas []string) ([]string, error) {
+		seen := make(map[string]bool)
+		for _, rdata := range rdatas {
+			err := check(rdata)
+			if err != nil {
+				return nil, fmt.Errorf("rdata: %v", err)
+			}
+			k := rdata
+			if key != nil {
+				k = key(rdata)
+			}
+			if _, ok := seen[k]; ok {
+				return nil, fmt.Errorf("rdata: duplicate entry: %v", rdata)
+			}
+			seen[k] = true
+		}
+		return rdatas, nil
+	}
+}
+
+func validateSOA(rdatas []string) ([]string, error) {
+	soa, err := ParseSOA(rdatas[0])
+	if err != nil {
+		return nil, fmt.Errorf("rdata: %v", err)
+	}
+	return []string{soa.String()}, nil
+}
+
+func validateMX(rdatas []string) ([]string, error) {
+	// accept RFC7505 null MX and skip tests in that case
+	if len(rdatas) == 1 && rdatas[0] == "0 ." {
+		return rdatas, nil
+	}
+	return validateEach(func(rdata string) error {
+		mxLine := strings.SplitN(rdata, " ", 2)
+		preference, err := strconv.ParseInt(mxLine[0], 10, 64)
+		if err != nil || len(mxLine) != 2 {
+			return fmt.Errorf("invalid preference: %v", mxLine[0])
+		}
+		// check preference
+		if preference < 0 || preference > 65535 {
+			return fmt.Errorf("invalid preference: %v", preference)
+		}
+		// check hostname
+		return lib.IsValidFQDN(mxLine[1])
+	}, func(rdata string) string {
+		return strings.SplitN(rdata, " ", 2)[1]
+	})(rdatas)
+}
+
+func validateTXT(rdatas []string) ([]string, error) {
+	rdatas, err := validateEach(func(rdata string) error {
+		// check empty
+		if len(rdata) == 0 {
+			return fmt.Errorf("empty")
+		}
+		// check maxlength
+		if len(rdata) > 255 {
+			return fmt.Errorf("too large")
+		}
+		return nil
+	}, nil)(rdatas)
+	if err != nil {
+		return nil, err
+	}
+	// some sanity checks to prevent the most common mistakes
+	spf1, dkim1 := false, false
+	for _, rdata := range rdatas {
+		rdataLower := strings.ToLower(rdata)
+		if strings.HasPrefix(rdataLower, "v=spf1 ") ||
+			strings.HasPrefix(rdataLower, "v=spf1;") {
+			if spf1 {
+				return nil, fmt.Errorf("rdata: SPF already set")
+			}
+			spf1 = true
+		}
+		if strings.HasPrefix(rdataLower, "v=dkim1 ") ||
+			strings.HasPrefix(rdataLower, "v=dkim1;") {
+			if dkim1 {
+				return nil, fmt.Errorf("rdata: DKIM already set")
+			}
+			dkim1 = true
+		}
+	}
+	return rdatas, nil
+}
+
+/* --- logic checks --------------------------------------------------------- */
+
+// coexisting returns the logic check of records that can coexist with other
+// data, but not with a delegation or CNAME record
+func coexisting(message string) func(nd *node) error {
+	return func(nd *node) error {
+		if nd.hasDelegation() || nd.hasCNAME() {
+			return errors.New(message)
+		}
+		return nil
+	}
+}
+
+func conflictsSOA(nd *node) error {
+	// A SOA record makes a FQDN the apex of a zone. The zone's own NS records
+	// coexist with it, they are no delegation.
+	if nd.hasCNAME() {
+		return fmt.Errorf("conflicting records")
+	}
+	return nil
+}
+
+func conflictsNS(nd *node) error {
+	// The NS records of a zone's apex are no delegation
+	if nd.hasSOA() {
+		return nil
+	}
+	// A FQDN can not have any other records it holds a delegation
+	if nd.hasRecords() {
+		return fmt.Errorf("conflicting records")
+	}
+	// A FQDN can not have sub-labels (children) when it holds a delegation
+	if nd.hasChildren() {
+		return fmt.Errorf("cannot delegate FQDN with children")
+	}
+	return nil
+}
+
+func conflictsCNAME(nd *node) error {
+	/*
+	 * RFC1912: A CNAME record is not allowed to coexist with any other data.
+	 * RFC1034: If a CNAME RR is present at a node, no other data should be
+	 * present; this ensures that the data for a canonical name and its aliases
+	 * cannot be different.
+	 */
+	if nd.hasRecords() {
+		return fmt.Errorf("conflicting records")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package rrdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupRType(t *testing.T) {
+	for _, name := range []string{"SOA", "NS", "MX", "TXT", "CNAME", "A", "AAAA"} {
+		rt, err := lookupRType(name)
+		assert.Equal(t, nil, err, name)
+		if err == nil {
+			assert.Equal(t, name, rt.name)
+		}
+	}
+	_, err := lookupRType("SRV")
+	assert.Equal(t, "unsupported record type: SRV", err.Error())
+}
+
+func TestDBSetAdd(t *testing.T) {
+	db := New()
+	assert.NotEqual(t, nil, db.set(testFQDN, validTTL, "SRV", []string{"foo"}))
+	assert.NotEqual(t, nil, db.add(testFQDN, validTTL, "SRV", "foo"))
+	_, err := db.get(testFQDN, validTTL, "SRV")
+	assert.NotEqual(t, nil, err)
+
+	// records are kept in the order they were added
+	for _, rdata := range []string{"c", "a", "b"} {
+		assert.Equal(t, nil, db.add(testFQDN, validTTL, "TXT", rdata))
+	}
+	record, err := db.get(testFQDN, 0, "TXT")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{`"c"`, `"a"`, `"b"`}, record.RDatas)
+
+	// records are retrieved in the order of their type numbers
+	assert.Equal(t, nil, db.set(testFQDN, validTTL, "A", validA))
+	assert.Equal(t, nil, db.set(testFQDN, validTTL, "SOA", []string{validSOA}))
+	records, err := db.Records(testFQDN, validTTL)
+	assert.Equal(t, nil, err)
+	rtypes := []string{}
+	for _, record := range records {
+		rtypes = append(rtypes, record.RType)
+	}
+	assert.Equal(t, []string{"A", "SOA", "TXT"}, rtypes)
+}
+
+func TestValidateMX(t *testing.T) {
+	_, err := validateMX([]string{"10"})
+	assert.Equal(t, "rdata: invalid preference: 10", err.Error())
+	_, err = validateMX([]string{"10 mx.example.com.", "20 mx.example.com."})
+	assert.Equal(t, "rdata: duplicate entry: 20 mx.example.com.", err.Error())
+	rdatas, err := validateMX([]string{"0 ."})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"0 ."}, rdatas)
+}
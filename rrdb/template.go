@@ -0,0 +1,378 @@
+package rrdb
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/egymgmbh/dns-tools/lib"
+	yaml "gopkg.in/yaml.v3"
+)
+
+var (
+	regexParameter = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+)
+
+// YAMLParameter struct to load YAML data into: A template parameter with an
+// optional default value. Parameters without default value are required.
+type yamlParameter struct {
+	Name        string
+	Description string
+	Default     *string
+}
+
+// YAMLTemplateRef struct to load YAML data into: A zone's reference to a
+// template with optional parameters. A reference may be written as plain
+// template name or as mapping with template name and parameters.
+type yamlTemplateRef struct {
+	Template   string
+	Parameters map[string]string
+	pos        position
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler and accepts plain template names
+// as well as mappings
+func (ref *yamlTemplateRef) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&ref.Template)
+	}
+	// decoding a node does not reject unknown keys
+	if value.Kind == yaml.MappingNode {
+		for idx := 0; idx+1 < len(value.Content); idx += 2 {
+			key := value.Content[idx]
+			if key.Value != "template" && key.Value != "parameters" &&
+				key.Tag != "!!merge" {
+				return fmt.Errorf("line %v: field %v not found in type %T",
+					key.Line, key.Value, *ref)
+			}
+		}
+	}
+	type plain yamlTemplateRef // prevents recursion
+	return value.Decode((*plain)(ref))
+}
+
+// templateStrings returns pointers to all fields of a name that may contain
+// template expressions
+func templateStrings(name *yamlName) []*string {
+	fields := []*string{&name.Name, &name.Forwarding.Target}
+	for idx := range name.Delegation.Nameservers {
+		fields = append(fields, &name.Delegation.Nameservers[idx])
+	}
+	for idx := range name.Mail.Mailservers {
+		fields = append(fields, &name.Mail.Mailservers[idx].Mailserver)
+	}
+	for idx := range name.Texts.Data {
+		fields = append(fields, &name.Texts.Data[idx])
+	}
+	for idx := range name.Addresses.Literals {
+		fields = append(fields, &name.Addresses.Literals[idx])
+	}
+	return fields
+}
+
+// render executes a single template expression. Strings without expressions
+// are returned untouched. A nil data map only checks the syntax.
+func render(s string, data map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		return s, nil
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// check validates a template's parameter definitions and the syntax of its
+// template expressions, including the parameters passed to included templates
+func (t *yamlTemplate) check() error {
+	seen := make(map[string]bool)
+	for _, parameter := range t.Parameters {
+		if !regexParameter.MatchString(parameter.Name) ||
+			parameter.Name == "Zone" {
+			return errorf(t.pos, "template %v: parameter %q: invalid name",
+				t.Template, parameter.Name)
+		}
+		if seen[parameter.Name] {
+			return errorf(t.pos, "template %v: parameter %v: duplicate",
+				t.Template, parameter.Name)
+		}
+		seen[parameter.Name] = true
+	}
+	for idx := range t.Names {
+		for _, field := range templateStrings(&t.Names[idx]) {
+			_, err := render(*field, nil)
+			if err != nil {
+				return errorf(t.Names[idx].pos, "template %v: name %v: %v",
+					t.Template, t.Names[idx].Name, err)
+			}
+		}
+	}
+	for _, ref := range t.Templates {
+		for key, value := range ref.Parameters {
+			_, err := render(value, nil)
+			if err != nil {
+				return errorf(ref.pos, "template %v: include %v: parameter %v: %v",
+					t.Template, ref.Template, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkIncludes verifies that all templates included by other templates exist
+// and that no template includes itself, neither directly nor indirectly.
+// Templates with broken includes are marked as broken themselves.
+func checkIncludes(templates map[string]yamlTemplate, problems *collector) {
+	done := make(map[string]bool)
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		for idx, seen := range chain {
+			if seen == name {
+				return errorf(templates[name].pos, "template %v: include cycle: %v",
+					name, strings.Join(append(chain[idx:], name), " -> "))
+			}
+		}
+		if done[name] {
+			return nil
+		}
+		chain = append(chain, name)
+		for _, ref := range templates[name].Templates {
+			include, ok := templates[ref.Template]
+			if !ok {
+				return errorf(ref.pos, "template %v: include %v: not found",
+					name, ref.Template)
+			}
+			if include.broken {
+				return fmt.Errorf("template %v: include %v: broken", name,
+					ref.Template)
+			}
+			err := visit(ref.Template, chain)
+			if err != nil {
+				return err
+			}
+		}
+		done[name] = true
+		return nil
+	}
+	names := []string{}
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := visit(name, nil)
+		if err != nil {
+			if _, ok := err.(*Problem); ok {
+				problems.add(err)
+			}
+			template := templates[name]
+			template.broken = true
+			templates[name] = template
+		}
+	}
+}
+
+// instantiate renders a template's names for a zone and view. The zone's FQDN
+// is available as {{ .Zone }}, parameters by their names, e.g. {{ .mx }}.
+// Unknown and missing parameters are reported as errors. Included templates are
+// resolved first, the template's own names are merged on top of them.
+func (t *yamlTemplate) instantiate(zone string, parameters map[string]string,
+	templates map[string]yamlTemplate, view string) ([]yamlName, error) {
+	data := map[string]string{"Zone": zone}
+	known := make(map[string]bool)
+	for _, parameter := range t.Parameters {
+		known[parameter.Name] = true
+		if parameter.Default != nil {
+			data[parameter.Name] = *parameter.Default
+		}
+	}
+	for key, value := range parameters {
+		if !known[key] {
+			return nil, fmt.Errorf("parameter %v: unknown", key)
+		}
+		data[key] = value
+	}
+	for _, parameter := range t.Parameters {
+		if _, ok := data[parameter.Name]; !ok {
+			return nil, fmt.Errorf("parameter %v: missing", parameter.Name)
+		}
+	}
+
+	origin := fmt.Sprintf("%v (%v)", t.Template, t.pos)
+	names := []yamlName{}
+	// included templates
+	for _, ref := range t.Templates {
+		include := templates[ref.Template]
+		includeParameters := make(map[string]string)
+		for key, value := range ref.Parameters {
+			value, err := render(value, data)
+			if err != nil {
+				return nil, fmt.Errorf("include %v (%v): parameter %v: %v",
+					ref.Template, ref.pos, key, err)
+			}
+			includeParameters[key] = value
+		}
+		includeNames, err := include.instantiate(zone, includeParameters,
+			templates, view)
+		if err != nil {
+			return nil, fmt.Errorf("include %v (%v): %v",
+				ref.Template, ref.pos, err)
+		}
+		for idx := range includeNames {
+			includeNames[idx].origin = append(includeNames[idx].origin, origin)
+		}
+		names = mergeNames(zone, view, names, includeNames)
+	}
+	// own names
+	ownNames := []yamlName{}
+	for _, name := range t.Names {
+		name = name.copy()
+		for _, field := range templateStrings(&name) {
+			value, err := render(*field, data)
+			if err != nil {
+				return nil, fmt.Errorf("name %v (%v): %v", name.Name, name.pos,
+					err)
+			}
+			*field = value
+		}
+		name.origin = []string{origin}
+		ownNames = append(ownNames, name)
+	}
+	return mergeNames(zone, view, names, ownNames), nil
+}
+
+// resolveZone resolves the templates of a zone and merges the zone's own
+// names on top of them. The result holds the names of a single view. Templates
+// that can not be instantiated are left out.
+func resolveZone(zone yamlZone, templates map[string]yamlTemplate,
+	view string, problems *collector) []yamlName {
+	names := []yamlName{}
+	for _, ref := range zone.Templates {
+		template, ok := templates[ref.Template]
+		if !ok {
+			problems.add(errorf(ref.pos, "zone %v: template %v: not found",
+				zone.Zone, ref.Template))
+			continue
+		}
+		if template.broken {
+			continue // errors have been reported for the template itself
+		}
+		templateNames, err := template.instantiate(zone.Zone, ref.Parameters,
+			templates, view)
+		if err != nil {
+			problems.add(errorf(ref.pos, "zone %v: template %v: %v",
+				zone.Zone, ref.Template, err))
+			continue
+		}
+		names = mergeNames(zone.Zone, view, names, templateNames)
+	}
+	return mergeNames(zone.Zone, view, names, zone.Names)
+}
+
+// mergeNames appends the names of a view to a list of already resolved names.
+// A name marked as override replaces the records of earlier definitions of the
+// same FQDN, see yamlName.without. Without override, overlapping definitions
+// are left to the conflict checks of the database.
+func mergeNames(zone, view string, names, more []yamlName) []yamlName {
+	for _, name := range more {
+		if !inView(name.Views, view) {
+			continue
+		}
+		if name.Override {
+			fqdn := lib.MakeFQDN(name.Name, zone)
+			for idx := range names {
+				if lib.MakeFQDN(names[idx].Name, zone) == fqdn {
+					names[idx] = names[idx].without(name)
+				}
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// rtypes lists the record types a name defines
+func (name yamlName) rtypes() []string {
+	rtypes := []string{}
+	if len(name.Delegation.Nameservers) != 0 || name.Delegation.ManagedZone {
+		rtypes = append(rtypes, "NS")
+	}
+	if len(name.Mail.Mailservers) != 0 {
+		rtypes = append(rtypes, "MX")
+	}
+	if len(name.Texts.Data) != 0 {
+		rtypes = append(rtypes, "TXT")
+	}
+	if len(strings.TrimSpace(name.Forwarding.Target)) != 0 {
+		rtypes = append(rtypes, "CNAME")
+	}
+	a, aaaa := false, false
+	for _, literal := range name.Addresses.Literals {
+		if lib.IsValidIPv4(literal) == nil {
+			a = true
+		} else {
+			aaaa = true
+		}
+	}
+	if a {
+		rtypes = append(rtypes, "A")
+	}
+	if aaaa {
+		rtypes = append(rtypes, "AAAA")
+	}
+	return rtypes
+}
+
+// without removes everything from a name that an overriding name replaces:
+// Sections the overriding name defines as well, NS and CNAME records if the
+// overriding name defines anything (they can not coexist with other data) and
+// everything if the overriding name defines NS or CNAME records. Addresses are
+// replaced as a whole, i.e. A and AAAA records together.
+func (name yamlName) without(override yamlName) yamlName {
+	replaced := make(map[string]bool)
+	for _, rtype := range override.rtypes() {
+		replaced[rtype] = true
+	}
+	if len(replaced) == 0 {
+		return name
+	}
+	exclusive := replaced["NS"] || replaced["CNAME"]
+	name.Delegation = yamlDelegation{}
+	name.Forwarding = yamlForwarding{}
+	if exclusive || replaced["MX"] {
+		name.Mail = yamlMail{}
+	}
+	if exclusive || replaced["TXT"] {
+		name.Texts = yamlTexts{}
+	}
+	if exclusive || replaced["A"] || replaced["AAAA"] {
+		name.Addresses = yamlAddresses{}
+	}
+	return name
+}
+
+// copy creates a deep copy of a name, so it can be modified without touching
+// the template it belongs to
+func (name yamlName) copy() yamlName {
+	name.Views = append([]string(nil), name.Views...)
+	name.Delegation.Nameservers = append([]string(nil),
+		name.Delegation.Nameservers...)
+	name.Mail.Mailservers = append([]yamlMailserver(nil),
+		name.Mail.Mailservers...)
+	name.Texts.Data = append([]string(nil), name.Texts.Data...)
+	name.Addresses.Literals = append([]string(nil), name.Addresses.Literals...)
+	name.origin = append([]string(nil), name.origin...)
+	return name
+}
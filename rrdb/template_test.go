@@ -0,0 +1,212 @@
+package rrdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestTemplateRefUnmarshalYAML(t *testing.T) {
+	{
+		var ref yamlTemplateRef
+		err := yaml.Unmarshal([]byte(`mail`), &ref)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, yamlTemplateRef{Template: "mail"}, ref)
+	}
+	{
+		var ref yamlTemplateRef
+		err := yaml.Unmarshal([]byte("template: mail\nparameters:\n  mx: mx.example.com.\n"), &ref)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, yamlTemplateRef{
+			Template:   "mail",
+			Parameters: map[string]string{"mx": "mx.example.com."},
+		}, ref)
+	}
+	{
+		var ref yamlTemplateRef
+		err := yaml.Unmarshal([]byte("template: mail\nparams: {}\n"), &ref)
+		assert.NotEqual(t, nil, err)
+	}
+}
+
+func TestTemplateCheck(t *testing.T) {
+	name := func(target string) yamlName {
+		var name yamlName
+		name.Name = "www"
+		name.Forwarding.Target = target
+		return name
+	}
+	mx := "mx.example.com."
+	tests := []struct {
+		template yamlTemplate
+		ok       bool
+	}{
+		{yamlTemplate{Names: []yamlName{name("{{ .Zone }}")}}, true},
+		{yamlTemplate{Names: []yamlName{name("{{ .Zone }")}}, false},
+		{yamlTemplate{
+			Parameters: []yamlParameter{{Name: "mx", Default: &mx}},
+			Names:      []yamlName{name("{{ .mx }}")},
+		}, true},
+		{yamlTemplate{Parameters: []yamlParameter{{Name: "Zone"}}}, false},
+		{yamlTemplate{Parameters: []yamlParameter{{Name: "1mx"}}}, false},
+		{yamlTemplate{Parameters: []yamlParameter{{Name: "mx"}, {Name: "mx"}}}, false},
+	}
+	for _, test := range tests {
+		err := test.template.check()
+		assert.Equal(t, test.ok, err == nil, test.template)
+	}
+}
+
+func TestTemplateInstantiate(t *testing.T) {
+	mx := "mx.example.net."
+	template := yamlTemplate{
+		Parameters: []yamlParameter{
+			{Name: "mx", Default: &mx},
+			{Name: "spf"},
+		},
+	}
+	template.Names = make([]yamlName, 1)
+	template.Names[0].Name = "@"
+	template.Names[0].Mail.Mailservers = []yamlMailserver{{Mailserver: "{{ .mx }}"}}
+	template.Names[0].Texts.Data = []string{"v=spf1 include:{{ .spf }} ~all"}
+	template.Names[0].Forwarding.Target = "www.{{ .Zone }}"
+
+	// defaults and zone
+	{
+		names, err := template.instantiate("example.com.", map[string]string{
+			"spf": "_spf.example.com",
+		}, nil, "")
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, "mx.example.net.", names[0].Mail.Mailservers[0].Mailserver)
+			assert.Equal(t, []string{"v=spf1 include:_spf.example.com ~all"},
+				names[0].Texts.Data)
+			assert.Equal(t, "www.example.com.", names[0].Forwarding.Target)
+		}
+	}
+	// overrides
+	{
+		names, err := template.instantiate("example.org.", map[string]string{
+			"mx":  "mx.example.org.",
+			"spf": "_spf.example.org",
+		}, nil, "")
+		assert.Equal(t, nil, err)
+		if err == nil {
+			assert.Equal(t, "mx.example.org.", names[0].Mail.Mailservers[0].Mailserver)
+		}
+	}
+	// the template itself stays untouched
+	assert.Equal(t, "{{ .mx }}", template.Names[0].Mail.Mailservers[0].Mailserver)
+	assert.Equal(t, "www.{{ .Zone }}", template.Names[0].Forwarding.Target)
+	// missing parameter
+	{
+		names, err := template.instantiate("example.com.", nil, nil, "")
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, []yamlName(nil), names)
+	}
+	// unknown parameter
+	{
+		names, err := template.instantiate("example.com.", map[string]string{
+			"spf":  "_spf.example.com",
+			"dkim": "selector1",
+		}, nil, "")
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, []yamlName(nil), names)
+	}
+}
+
+func TestCheckIncludes(t *testing.T) {
+	include := func(names ...string) []yamlTemplateRef {
+		refs := []yamlTemplateRef{}
+		for _, name := range names {
+			refs = append(refs, yamlTemplateRef{Template: name})
+		}
+		return refs
+	}
+	tests := []struct {
+		templates map[string]yamlTemplate
+		ok        bool
+	}{
+		{map[string]yamlTemplate{
+			"a": {Templates: include("b", "c")},
+			"b": {Templates: include("c")},
+			"c": {},
+		}, true},
+		{map[string]yamlTemplate{
+			"a": {Templates: include("a")},
+		}, false},
+		{map[string]yamlTemplate{
+			"a": {Templates: include("b")},
+			"b": {Templates: include("c")},
+			"c": {Templates: include("a")},
+		}, false},
+		{map[string]yamlTemplate{
+			"a": {Templates: include("b")},
+		}, false},
+	}
+	for _, test := range tests {
+		problems := newCollector()
+		checkIncludes(test.templates, problems)
+		assert.Equal(t, test.ok, problems.err() == nil, test.templates)
+		broken := false
+		for _, template := range test.templates {
+			broken = broken || template.broken
+		}
+		assert.Equal(t, !test.ok, broken, test.templates)
+	}
+}
+
+func TestNameWithout(t *testing.T) {
+	var name yamlName
+	name.Name = "@"
+	name.Mail.Mailservers = []yamlMailserver{{Mailserver: "mx.example.com."}}
+	name.Texts.Data = []string{"v=spf1 -all"}
+	name.Addresses.Literals = []string{"192.0.2.1", "2001:db8::1"}
+	assert.Equal(t, []string{"MX", "TXT", "A", "AAAA"}, name.rtypes())
+
+	// same record types are replaced
+	var override yamlName
+	override.Mail.Mailservers = []yamlMailserver{{Mailserver: "mx.example.org."}}
+	assert.Equal(t, []string{"TXT", "A", "AAAA"}, name.without(override).rtypes())
+	// addresses are replaced as a whole
+	override = yamlName{}
+	override.Addresses.Literals = []string{"192.0.2.2"}
+	assert.Equal(t, []string{"MX", "TXT"}, name.without(override).rtypes())
+	// forwardings replace everything
+	override = yamlName{}
+	override.Forwarding.Target = "example.org."
+	assert.Equal(t, []string{}, name.without(override).rtypes())
+	// nothing to replace
+	assert.Equal(t, name, name.without(yamlName{}))
+	// anything replaces forwardings
+	var forwarding yamlName
+	forwarding.Forwarding.Target = "example.org."
+	override = yamlName{}
+	override.Texts.Data = []string{"v=spf1 -all"}
+	assert.Equal(t, []string{}, forwarding.without(override).rtypes())
+}
+
+func TestMergeNames(t *testing.T) {
+	var mx, txt, override yamlName
+	mx.Name = "@"
+	mx.Mail.Mailservers = []yamlMailserver{{Mailserver: "mx.example.net."}}
+	txt.Name = "example.com."
+	txt.Texts.Data = []string{"v=spf1 -all"}
+	override.Name = "@"
+	override.Override = true
+	override.Views = []string{"internal"}
+	override.Mail.Mailservers = []yamlMailserver{{Mailserver: "mx.example.com."}}
+
+	// override in view, same FQDN written differently
+	names := mergeNames("example.com.", "internal", []yamlName{mx, txt},
+		[]yamlName{override})
+	assert.Equal(t, 3, len(names))
+	assert.Equal(t, []string{}, names[0].rtypes())
+	assert.Equal(t, []string{"TXT"}, names[1].rtypes())
+	assert.Equal(t, []string{"MX"}, names[2].rtypes())
+	// override not in view
+	names = mergeNames("example.com.", "", []yamlName{mx, txt},
+		[]yamlName{override})
+	assert.Equal(t, []yamlName{mx, txt}, names)
+}
@@ -0,0 +1,181 @@
+package rrdb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	regexVariable = regexp.MustCompile(`^[a-z0-9]([a-z0-9\-]*[a-z0-9])?$`)
+)
+
+// yamlVariable holds a named list of values, e.g. an address pool, defined at
+// the top level of a zonedata file
+type yamlVariable struct {
+	values []string
+	pos    position
+}
+
+// Variable describes a variable along with the names using it
+type Variable struct {
+	Name     string
+	Position string // file:line:col of the variable's definition
+	Values   []string
+	Uses     []Origin
+}
+
+// buildVariables builds the variables map. Variables are global to a directory,
+// so every variable must be defined exactly once.
+func buildVariables(yamlFileDatas map[string]yamlFile,
+	problems *collector) map[string]yamlVariable {
+	variables := make(map[string]yamlVariable)
+	for _, fname := range sortedFnames(yamlFileDatas) {
+		yamlFileData := yamlFileDatas[fname]
+		names := []string{}
+		for name := range yamlFileData.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			values := yamlFileData.Variables[name]
+			pos, ok := yamlFileData.variablePositions[name]
+			if !ok {
+				pos = position{file: fname}
+			}
+			if !regexVariable.MatchString(name) {
+				problems.add(errorf(pos, "variable %q: invalid name", name))
+			}
+			if len(values) == 0 {
+				problems.add(errorf(pos, "variable %v: empty", name))
+			}
+			if variable, seen := variables[name]; seen {
+				problems.add(errorf(pos, "variable %v: duplicate, already defined at %v",
+					name, variable.pos))
+				continue
+			}
+			variables[name] = yamlVariable{
+				values: values,
+				pos:    pos,
+			}
+		}
+	}
+	return variables
+}
+
+// expandList replaces references to variables, written as $name, in a list by
+// the variable's values. It returns the expanded list and the names of the
+// variables used.
+func expandList(list []string, variables map[string]yamlVariable) ([]string, []string, error) {
+	expanded := []string{}
+	used := []string{}
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if !strings.HasPrefix(entry, "$") {
+			expanded = append(expanded, entry)
+			continue
+		}
+		variable, ok := variables[entry[1:]]
+		if !ok {
+			return nil, nil, fmt.Errorf("variable %v: undefined", entry[1:])
+		}
+		expanded = append(expanded, variable.values...)
+		used = append(used, entry[1:])
+	}
+	return expanded, used, nil
+}
+
+// expand replaces references to variables in the address literals and
+// nameservers of a name of a zone by the variable's values. It returns the
+// expanded name and, by record type, the names of the variables used.
+func (name yamlName) expand(variables map[string]yamlVariable,
+	zone yamlZone) (yamlName, map[string][]string, error) {
+	used := make(map[string][]string)
+	if len(name.Delegation.Nameservers) != 0 {
+		nameservers, nsUsed, err := expandList(name.Delegation.Nameservers,
+			variables)
+		if err != nil {
+			pos, where := name.where(zone, "delegation")
+			return name, nil, errorf(pos, "%v: %v", where, err)
+		}
+		name.Delegation.Nameservers = nameservers
+		if len(nsUsed) != 0 {
+			used["NS"] = nsUsed
+		}
+	}
+	if len(name.Addresses.Literals) != 0 {
+		literals, addressesUsed, err := expandList(name.Addresses.Literals,
+			variables)
+		if err != nil {
+			pos, where := name.where(zone, "addresses")
+			return name, nil, errorf(pos, "%v: %v", where, err)
+		}
+		name.Addresses.Literals = literals
+		for _, variable := range addressesUsed {
+			pool := yamlName{}
+			pool.Addresses.Literals = variables[variable].values
+			for _, rtype := range pool.rtypes() {
+				used[rtype] = append(used[rtype], variable)
+			}
+		}
+	}
+	return name, used, nil
+}
+
+// VariablesFromDirectory returns the variables defined in a directory of
+// YAML-formatted zonedata files, ordered by name, along with the names using
+// them in any view
+func VariablesFromDirectory(directory string) ([]Variable, error) {
+	problems := newCollector()
+	l := newLoader(directory, problems)
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	uses := make(map[string][]Origin)
+	seen := make(map[string]bool)
+	for _, view := range append([]string{""}, l.views...) {
+		for _, fname := range l.fnames() {
+			for _, zone := range l.yamlFileDatas[fname].Zones {
+				if !inView(zone.Views, view) {
+					continue
+				}
+				names := resolveZone(zone, l.templates, view, problems)
+				if err := problems.err(); err != nil {
+					return nil, err
+				}
+				for _, name := range names {
+					_, used, err := name.expand(l.variables, zone)
+					if err != nil {
+						return nil, err
+					}
+					for _, rtype := range []string{"NS", "A", "AAAA"} {
+						for _, variable := range used[rtype] {
+							origin := name.describe(zone, []string{rtype})
+							key := fmt.Sprint(variable, origin)
+							if seen[key] {
+								continue // same use in another view
+							}
+							seen[key] = true
+							uses[variable] = append(uses[variable], origin)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	variables := []Variable{}
+	for name, variable := range l.variables {
+		variables = append(variables, Variable{
+			Name:     name,
+			Position: variable.pos.String(),
+			Values:   variable.values,
+			Uses:     uses[name],
+		})
+	}
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].Name < variables[j].Name
+	})
+	return variables, nil
+}
@@ -0,0 +1,100 @@
+package rrdb
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandList(t *testing.T) {
+	variables := map[string]yamlVariable{
+		"lb-eu": {values: []string{"192.0.2.10", "2001:db8::10"}},
+	}
+	{
+		list, used, err := expandList([]string{"192.0.2.1", " $lb-eu"}, variables)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []string{"192.0.2.1", "192.0.2.10", "2001:db8::10"}, list)
+		assert.Equal(t, []string{"lb-eu"}, used)
+	}
+	{
+		list, used, err := expandList([]string{"$lb-us"}, variables)
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, []string(nil), list)
+		assert.Equal(t, []string(nil), used)
+	}
+}
+
+func TestBuildVariables(t *testing.T) {
+	{
+		problems := newCollector()
+		variables := buildVariables(map[string]yamlFile{
+			"a.yml": {Variables: map[string][]string{"lb-eu": {"192.0.2.10"}}},
+			"b.yml": {Variables: map[string][]string{"lb-us": {"192.0.2.11"}}},
+		}, problems)
+		assert.Equal(t, Problems(nil), problems.problems)
+		assert.Equal(t, map[string]yamlVariable{
+			"lb-eu": {values: []string{"192.0.2.10"}, pos: position{file: "a.yml"}},
+			"lb-us": {values: []string{"192.0.2.11"}, pos: position{file: "b.yml"}},
+		}, variables)
+	}
+	// the later file is blamed for duplicates
+	{
+		problems := newCollector()
+		variables := buildVariables(map[string]yamlFile{
+			"b.yml": {
+				Variables: map[string][]string{"lb-eu": {"192.0.2.11"}},
+				variablePositions: map[string]position{
+					"lb-eu": {file: "b.yml", line: 3, column: 3},
+				},
+			},
+			"a.yml": {
+				Variables: map[string][]string{"lb-eu": {"192.0.2.10"}},
+				variablePositions: map[string]position{
+					"lb-eu": {file: "a.yml", line: 7, column: 3},
+				},
+			},
+		}, problems)
+		assert.Equal(t, Problems{{
+			Position: "b.yml:3:3",
+			Severity: SeverityError,
+			Message:  "variable lb-eu: duplicate, already defined at a.yml:7:3",
+		}}, problems.problems)
+		assert.Equal(t, map[string]yamlVariable{
+			"lb-eu": {
+				values: []string{"192.0.2.10"},
+				pos:    position{file: "a.yml", line: 7, column: 3},
+			},
+		}, variables)
+	}
+}
+
+func TestVariablesFromDirectory(t *testing.T) {
+	directory := path.Join("testdata", "pass", "variables")
+	variables, err := VariablesFromDirectory(directory)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	pools := path.Join(directory, "pools.yml")
+	zones := path.Join(directory, "zones.yml")
+	web := []string{"web (" + zones + ":3:5)"}
+	www := func(rtype string) Origin {
+		return Origin{"www.example.com.", []string{rtype}, zones + ":5:9",
+			"example.com.", zones + ":11:5", web, nil}
+	}
+	apex := func(rtype string) Origin {
+		return Origin{"example.com.", []string{rtype}, zones + ":15:9",
+			"example.com.", zones + ":11:5", nil, nil}
+	}
+	assert.Equal(t, []Variable{
+		{"lb-eu", pools + ":3:3", []string{"192.0.2.10", "192.0.2.11",
+			"2001:db8::10"}, []Origin{www("A"), www("AAAA"), apex("A"),
+			apex("AAAA")}},
+		{"lb-us", pools + ":7:3", []string{"198.51.100.10"}, []Origin{apex("A")}},
+		{"ns-sub", pools + ":9:3", []string{"ns1.example.net.",
+			"ns2.example.net."}, []Origin{{"sub.example.com.", []string{"NS"},
+			zones + ":21:9", "example.com.", zones + ":11:5", nil, nil}}},
+		{"unused", pools + ":12:3", []string{"203.0.113.1"}, nil},
+	}, variables)
+}